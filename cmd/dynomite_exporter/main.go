@@ -14,7 +14,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"github.com/foxdalas/dynomite-exporter/pkg/config"
+	"github.com/foxdalas/dynomite-exporter/pkg/discovery"
 	"github.com/foxdalas/dynomite-exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -26,15 +31,130 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
+// scrapeTimeoutOffset is subtracted from the timeout Prometheus announces, so
+// the response still reaches it before it gives up on the scrape.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// scrapeContext returns the context for scraping dynomite nodes on behalf of
+// r. It is done when r is canceled or, if Prometheus announces its scrape
+// timeout, shortly before that timeout so the response still reaches it.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return context.WithCancel(r.Context())
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return context.WithCancel(r.Context())
+	}
+	if t := time.Duration(seconds*float64(time.Second)) - scrapeTimeoutOffset; t > 0 {
+		return context.WithTimeout(r.Context(), t)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// metricsHandler serves the metrics of the default registry together with
+// those of c, collected within the scrape context of r.
+func metricsHandler(w http.ResponseWriter, r *http.Request, c exporter.ContextCollector) {
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter.WithContext(ctx, c))
+
+	h := promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, registry}, promhttp.HandlerOpts{})
+	h.ServeHTTP(w, r)
+}
+
+// probeHandler scrapes the dynomite node given in the target query parameter
+// using a fresh registry, so a single exporter can serve a whole ring. The
+// target is either the name of a configured target or an address. Scrapes of
+// configured targets are recorded in the shared scrape metrics, those of
+// other addresses only in the probe response, so that arbitrary target
+// parameters do not accumulate series.
+func probeHandler(w http.ResponseWriter, r *http.Request, targets *exporter.TargetCollector, timeout time.Duration) {
+	name := r.URL.Query().Get("target")
+	if name == "" {
+		http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+		return
+	}
+
+	target, ok := targets.Target(name)
+	if !ok {
+		target = exporter.Target{Name: name, Address: exporter.NormalizeAddress(name)}
+	}
+	if target.Timeout == 0 {
+		target.Timeout = timeout
+	}
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	scrapeMetrics := targets.ScrapeMetrics()
+	if !ok {
+		scrapeMetrics = exporter.NewScrapeMetrics()
+		registry.MustRegister(scrapeMetrics)
+	}
+	registry.MustRegister(exporter.WithContext(ctx, targets.NewExporter(target, scrapeMetrics)))
+
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	h.ServeHTTP(w, r)
+}
+
+// reloadTargets replaces the static targets and service discovery of manager
+// with those of the configuration file, whenever a reload is requested on
+// reloadCh or by SIGHUP. An invalid configuration is reported and the current
+// targets are kept.
+func reloadTargets(configFile string, manager *discovery.Manager, reloadCh <-chan chan error, logger log.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	reload := func() error {
+		cfg, err := config.LoadFile(configFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error reloading config", "err", err)
+			return err
+		}
+		discoverers := cfg.Discoverers(logger)
+		manager.ApplyConfig(cfg.ExporterTargets(), discoverers)
+		level.Info(logger).Log("msg", "Reloaded config file", "targets", len(cfg.Targets), "discoverers", len(discoverers))
+		return nil
+	}
+
+	for {
+		select {
+		case <-hup:
+			reload()
+		case errCh := <-reloadCh:
+			errCh <- reload()
+		}
+	}
+}
+
 func main() {
 	var (
-		address       = kingpin.Flag("dynomite.address", "dynomite server address.").Default("localhost:22222").String()
-		timeout       = kingpin.Flag("dynomite.timeout", "dymonite connect timeout.").Default("1s").Duration()
-		webConfig     = webflag.AddFlags(kingpin.CommandLine)
-		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9122").String()
-		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		configFile       = kingpin.Flag("config.file", "Configuration file listing the dynomite nodes to scrape on the metrics path, instead of --dynomite.address.").Default("").String()
+		address          = kingpin.Flag("dynomite.address", "dynomite stats endpoint address: host:port, an http:// or https:// URL, or the path of a unix socket, bare or as a unix:// URL.").Default("localhost:22222").String()
+		httpClientConfig = kingpin.Flag("dynomite.http-client-config", "Path to a YAML file with the TLS, authentication and proxy settings of a Prometheus http_client_config, for requesting --dynomite.address, probed addresses and configured targets without their own.").Default("").String()
+		timeout          = kingpin.Flag("dynomite.timeout", "Timeout for scraping the dynomite stats endpoint.").Default("1s").Duration()
+		retries          = kingpin.Flag("dynomite.retries", "Number of times a failed request to the dynomite stats endpoint is retried within the timeout.").Default("0").Int()
+		retryBackoff     = kingpin.Flag("dynomite.retry-backoff", "Time waited before the first retry, doubled on every retry, with jitter.").Default("100ms").Duration()
+		breakerThreshold = kingpin.Flag("dynomite.breaker-threshold", "Number of consecutive failed scrapes of a dynomite node after which it is no longer requested for the breaker cooldown. 0 disables the circuit breaker.").Default("0").Int()
+		breakerCooldown  = kingpin.Flag("dynomite.breaker-cooldown", "Time a dynomite node is no longer requested once its circuit breaker opens.").Default("30s").Duration()
+		concurrency      = kingpin.Flag("dynomite.scrape-concurrency", "Maximum number of dynomite nodes listed in the configuration file scraped at once.").Default("10").Int()
+		pollInterval     = kingpin.Flag("dynomite.poll-interval", "Poll the dynomite nodes scraped on the metrics path in the background at this interval, and serve the results of the last poll, instead of scraping them on every scrape. 0 disables polling.").Default("0s").Duration()
+		webConfig        = webflag.AddFlags(kingpin.CommandLine)
+		listenAddress    = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9122").String()
+		metricsPath      = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		state            = kingpin.Flag("collector.state", "Export the node state from the /state/get_state admin endpoint, which not all dynomite builds serve.").Default("false").Bool()
+		topology         = kingpin.Flag("collector.topology", "Export the ring as seen by the node from the /cluster_describe admin endpoint.").Default("false").Bool()
+		legacyMetrics    = kingpin.Flag("compat.legacy-metric-names", "Also export the latency, size and memory metrics under their names and units from before the base unit conversion. Will be removed in a future release.").Default("false").Bool()
 	)
 
 	promlogConfig := &promlog.Config{}
@@ -48,15 +168,81 @@ func main() {
 	level.Info(logger).Log("msg", "Build context", "context", version.BuildContext())
 
 	prometheus.MustRegister(version.NewCollector("dynomite_exporter"))
-	prometheus.MustRegister(exporter.New(*address, *timeout, logger))
+	scrapeMetrics := exporter.NewScrapeMetrics()
+	prometheus.MustRegister(scrapeMetrics)
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	client := exporter.NewHTTPClient()
+	if *httpClientConfig != "" {
+		cfg, err := config.LoadHTTPClientConfigFile(*httpClientConfig)
+		if err == nil {
+			client, err = exporter.NewHTTPClientFromConfig(cfg)
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "Error loading HTTP client config", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	opts := exporter.Options{
+		Client:           client,
+		Timeout:          *timeout,
+		LegacyMetrics:    *legacyMetrics,
+		State:            *state,
+		Topology:         *topology,
+		ScrapeMetrics:    scrapeMetrics,
+		PollInterval:     *pollInterval,
+		Concurrency:      *concurrency,
+		Retries:          *retries,
+		RetryBackoff:     *retryBackoff,
+		BreakerThreshold: *breakerThreshold,
+		BreakerCooldown:  *breakerCooldown,
+	}
+	targets := exporter.NewTargetCollector(opts, logger)
+	var collector exporter.ContextCollector
+
+	if *configFile != "" {
+		cfg, err := config.LoadFile(*configFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error loading config", "err", err)
+			os.Exit(1)
+		}
+		manager := discovery.NewManager(targets, logger)
+		manager.ApplyConfig(cfg.ExporterTargets(), cfg.Discoverers(logger))
+		collector = targets
+
+		reloadCh := make(chan chan error)
+		go reloadTargets(*configFile, manager, reloadCh, logger)
+		http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				fmt.Fprintf(w, "This endpoint requires a POST request.\n")
+				return
+			}
+			errCh := make(chan error)
+			reloadCh <- errCh
+			if err := <-errCh; err != nil {
+				http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			}
+		})
+	} else {
+		e := exporter.New(*address, opts, logger)
+		e.Start()
+		collector = e
+	}
+
+	http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricsHandler(w, r, collector)
+	})))
+	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		probeHandler(w, r, targets, *timeout)
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>Dynomite Exporter</title></head>
              <body>
              <h1>Dynomite Exporter</h1>
              <p><a href='` + *metricsPath + `'>Metrics</a></p>
+             <p><a href='/probe?target=localhost:22222'>Probe localhost:22222</a></p>
              </body>
              </html>`))
 	})
@@ -0,0 +1,231 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"github.com/foxdalas/dynomite-exporter/pkg/discovery"
+	"github.com/foxdalas/dynomite-exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	commonconfig "github.com/prometheus/common/config"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// Config is the configuration file of the exporter.
+type Config struct {
+	Targets             []TargetConfig                  `yaml:"targets"`
+	FileSDConfigs       []*discovery.FileSDConfig       `yaml:"file_sd_configs,omitempty"`
+	DNSSDConfigs        []*discovery.DNSSDConfig        `yaml:"dns_sd_configs,omitempty"`
+	ConsulSDConfigs     []*discovery.ConsulSDConfig     `yaml:"consul_sd_configs,omitempty"`
+	KubernetesSDConfigs []*discovery.KubernetesSDConfig `yaml:"kubernetes_sd_configs,omitempty"`
+	RingSDConfigs       []*discovery.RingSDConfig       `yaml:"ring_sd_configs,omitempty"`
+	FloridaSDConfigs    []*discovery.FloridaSDConfig    `yaml:"florida_sd_configs,omitempty"`
+}
+
+// TargetConfig configures a dynomite node to scrape.
+type TargetConfig struct {
+	// Name defaults to the address.
+	Name    string            `yaml:"name,omitempty"`
+	Address string            `yaml:"address"`
+	Timeout time.Duration     `yaml:"timeout,omitempty"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+	// HTTPClientConfig configures TLS, authentication and the proxy for
+	// requesting the stats endpoint.
+	HTTPClientConfig commonconfig.HTTPClientConfig `yaml:",inline"`
+}
+
+// Load parses and validates the YAML configuration in s.
+func Load(s string) (*Config, error) {
+	return load(s, "")
+}
+
+// load parses the YAML configuration in s, resolves relative file paths
+// against dir if not empty, and validates it.
+func load(s, dir string) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict([]byte(s), cfg); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		cfg.SetDirectory(dir)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile parses and validates the YAML configuration file filename.
+// Relative file paths in it are resolved against the directory of filename.
+func LoadFile(filename string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := load(string(content), filepath.Dir(filename))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// SetDirectory joins the relative paths of the files the HTTP client
+// configurations refer to, such as ca_file or password_file, to dir.
+func (c *Config) SetDirectory(dir string) {
+	for i := range c.Targets {
+		c.Targets[i].HTTPClientConfig.SetDirectory(dir)
+	}
+	for _, sd := range c.FileSDConfigs {
+		sd.HTTPClientConfig.SetDirectory(dir)
+	}
+	for _, sd := range c.DNSSDConfigs {
+		sd.HTTPClientConfig.SetDirectory(dir)
+	}
+	for _, sd := range c.ConsulSDConfigs {
+		sd.HTTPClientConfig.SetDirectory(dir)
+	}
+	for _, sd := range c.KubernetesSDConfigs {
+		sd.HTTPClientConfig.SetDirectory(dir)
+	}
+	for _, sd := range c.RingSDConfigs {
+		sd.HTTPClientConfig.SetDirectory(dir)
+	}
+	for _, sd := range c.FloridaSDConfigs {
+		sd.HTTPClientConfig.SetDirectory(dir)
+	}
+}
+
+// LoadHTTPClientConfigFile parses the YAML HTTP client configuration file
+// filename, in the format of a Prometheus http_client_config. Relative file
+// paths in it are resolved against the directory of filename.
+func LoadHTTPClientConfigFile(filename string) (*commonconfig.HTTPClientConfig, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &commonconfig.HTTPClientConfig{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	cfg.SetDirectory(filepath.Dir(filename))
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	names := make(map[string]bool, len(c.Targets))
+	for _, t := range c.ExporterTargets() {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+		names[t.Name] = true
+	}
+	for _, c := range c.FileSDConfigs {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range c.DNSSDConfigs {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range c.ConsulSDConfigs {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range c.KubernetesSDConfigs {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range c.RingSDConfigs {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range c.FloridaSDConfigs {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExporterTargets returns the configured targets.
+func (c *Config) ExporterTargets() []exporter.Target {
+	targets := make([]exporter.Target, 0, len(c.Targets))
+	for _, t := range c.Targets {
+		name := t.Name
+		if name == "" {
+			name = t.Address
+		}
+		target := exporter.Target{
+			Name:    name,
+			Address: exporter.NormalizeAddress(t.Address),
+			Timeout: t.Timeout,
+			Labels:  t.Labels,
+		}
+		if !reflect.DeepEqual(t.HTTPClientConfig, commonconfig.HTTPClientConfig{}) {
+			httpClientConfig := t.HTTPClientConfig
+			target.HTTPClientConfig = &httpClientConfig
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// Discoverers returns the configured service discovery mechanisms, keyed by a
+// name unique within the configuration.
+func (c *Config) Discoverers(logger log.Logger) map[string]discovery.Discoverer {
+	discoverers := make(map[string]discovery.Discoverer)
+	for i, sd := range c.FileSDConfigs {
+		name := fmt.Sprintf("file_sd/%d", i)
+		d := discovery.NewFileDiscovery(sd, log.With(logger, "discovery", name))
+		discoverers[name] = discovery.WithHTTPClientConfig(d, sd.HTTPClientConfig)
+	}
+	for i, sd := range c.DNSSDConfigs {
+		name := fmt.Sprintf("dns_sd/%d", i)
+		d := discovery.NewDNSDiscovery(sd, log.With(logger, "discovery", name))
+		discoverers[name] = discovery.WithHTTPClientConfig(d, sd.HTTPClientConfig)
+	}
+	for i, sd := range c.ConsulSDConfigs {
+		name := fmt.Sprintf("consul_sd/%d", i)
+		d := discovery.NewConsulDiscovery(sd, log.With(logger, "discovery", name))
+		discoverers[name] = discovery.WithHTTPClientConfig(d, sd.HTTPClientConfig)
+	}
+	for i, sd := range c.KubernetesSDConfigs {
+		name := fmt.Sprintf("kubernetes_sd/%d", i)
+		d := discovery.NewKubernetesDiscovery(sd, log.With(logger, "discovery", name))
+		discoverers[name] = discovery.WithHTTPClientConfig(d, sd.HTTPClientConfig)
+	}
+	for i, sd := range c.RingSDConfigs {
+		name := fmt.Sprintf("ring_sd/%d", i)
+		d := discovery.NewRingDiscovery(sd, log.With(logger, "discovery", name))
+		discoverers[name] = discovery.WithHTTPClientConfig(d, sd.HTTPClientConfig)
+	}
+	for i, sd := range c.FloridaSDConfigs {
+		name := fmt.Sprintf("florida_sd/%d", i)
+		d := discovery.NewFloridaDiscovery(sd, log.With(logger, "discovery", name))
+		discoverers[name] = discovery.WithHTTPClientConfig(d, sd.HTTPClientConfig)
+	}
+	return discoverers
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			config: `
+targets:
+  - name: dynomite-1
+    address: dynomite-1:22222
+    labels: {cluster: main}
+  - address: dynomite-2:22222
+file_sd_configs:
+  - files: [/etc/dynomite_exporter/targets/*.json]
+`,
+		},
+		{
+			name: "duplicate name",
+			config: `
+targets:
+  - name: dynomite-1
+    address: dynomite-1:22222
+  - name: dynomite-1
+    address: dynomite-2:22222
+`,
+			wantErr: `duplicate target name "dynomite-1"`,
+		},
+		{
+			name: "duplicate address",
+			config: `
+targets:
+  - address: dynomite-1:22222
+  - address: dynomite-1:22222
+`,
+			wantErr: `duplicate target name "dynomite-1:22222"`,
+		},
+		{
+			name: "reserved label",
+			config: `
+targets:
+  - address: dynomite-1:22222
+    labels: {rack: rack-1}
+`,
+			wantErr: `label name "rack" is reserved`,
+		},
+		{
+			name: "invalid label",
+			config: `
+targets:
+  - address: dynomite-1:22222
+    labels: {cluster-name: main}
+`,
+			wantErr: `invalid label name "cluster-name"`,
+		},
+		{
+			name: "unknown key",
+			config: `
+targets:
+  - address: dynomite-1:22222
+    timeuot: 2s
+`,
+			wantErr: "field timeuot not found",
+		},
+		{
+			name: "unknown sd key",
+			config: `
+dns_sd_configs:
+  - names: [dynomite.example.com]
+    typ: A
+`,
+			wantErr: "field typ not found",
+		},
+		{
+			name: "invalid sd config",
+			config: `
+file_sd_configs:
+  - files: [/etc/dynomite_exporter/targets.txt]
+`,
+			wantErr: "must end in .json, .yml or .yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFileRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "dynomite.yml")
+	config := `
+targets:
+  - address: dynomite-1:22222
+    basic_auth:
+      username: exporter
+      password_file: password
+ring_sd_configs:
+  - seeds: [dynomite-1:22222]
+    http_client_config:
+      bearer_token_file: secrets/token
+`
+	if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.Targets[0].HTTPClientConfig.BasicAuth.PasswordFile, filepath.Join(dir, "password"); got != want {
+		t.Errorf("password_file = %q, want %q", got, want)
+	}
+	if got, want := cfg.RingSDConfigs[0].HTTPClientConfig.BearerTokenFile, filepath.Join(dir, "secrets/token"); got != want {
+		t.Errorf("bearer_token_file = %q, want %q", got, want)
+	}
+}
+
+func TestLoadHTTPClientConfigFileRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "http.yml")
+	if err := ioutil.WriteFile(filename, []byte("bearer_token_file: token\ntls_config: {ca_file: /etc/ca.crt}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadHTTPClientConfigFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.BearerTokenFile, filepath.Join(dir, "token"); got != want {
+		t.Errorf("bearer_token_file = %q, want %q", got, want)
+	}
+	if cfg.TLSConfig.CAFile != "/etc/ca.crt" {
+		t.Errorf("ca_file = %q, want it unchanged", cfg.TLSConfig.CAFile)
+	}
+}
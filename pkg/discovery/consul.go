@@ -0,0 +1,219 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/foxdalas/dynomite-exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/config"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulSDConfig configures the discovery of dynomite nodes registered as a
+// service in the Consul catalog.
+type ConsulSDConfig struct {
+	// Server is the address of the Consul HTTP API.
+	Server string `yaml:"server,omitempty"`
+	Token  string `yaml:"token,omitempty"`
+	// Datacenter defaults to the one of the agent queried.
+	Datacenter string `yaml:"datacenter,omitempty"`
+	Service    string `yaml:"service"`
+	// Tags filters the service instances to those having all of the tags.
+	Tags []string `yaml:"tags,omitempty"`
+	// Port is the admin port of the nodes, defaulting to the service port.
+	Port int `yaml:"port,omitempty"`
+	// RefreshInterval is how often the catalog is queried.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+	// Timeout is the timeout of querying the catalog.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// HTTPClientConfig configures TLS, authentication and the proxy for
+	// requesting the stats endpoints of the nodes found.
+	HTTPClientConfig *config.HTTPClientConfig `yaml:"http_client_config,omitempty"`
+}
+
+const (
+	// DefaultConsulServer is the server of a ConsulSDConfig not setting one.
+	DefaultConsulServer = "localhost:8500"
+	// DefaultConsulSDRefreshInterval is the refresh interval of a
+	// ConsulSDConfig not setting one.
+	DefaultConsulSDRefreshInterval = 30 * time.Second
+	// DefaultConsulSDTimeout is the timeout of a ConsulSDConfig not setting
+	// one.
+	DefaultConsulSDTimeout = 5 * time.Second
+)
+
+// Validate checks the configuration.
+func (c *ConsulSDConfig) Validate() error {
+	if c.Service == "" {
+		return fmt.Errorf("consul_sd_config has no service")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("consul_sd_config: invalid port %d", c.Port)
+	}
+	return validateHTTPClientConfig("consul_sd_config", c.HTTPClientConfig)
+}
+
+// consulService is an instance of a service in the Consul catalog, as
+// returned by /v1/catalog/service/<service>.
+type consulService struct {
+	ID             string
+	Node           string
+	Address        string
+	Datacenter     string
+	ServiceID      string
+	ServiceAddress string
+	ServicePort    int
+	ServiceMeta    map[string]string
+}
+
+// invalidLabelCharRE matches the characters not allowed in label names.
+var invalidLabelCharRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// ConsulDiscovery finds dynomite nodes by querying the Consul catalog every
+// refresh interval.
+type ConsulDiscovery struct {
+	cfg      ConsulSDConfig
+	url      string
+	interval time.Duration
+	timeout  time.Duration
+	client   *http.Client
+	logger   log.Logger
+
+	// groups holds the groups last found, which are kept while the catalog
+	// cannot be queried.
+	groups []*Group
+}
+
+// NewConsulDiscovery returns a discoverer for cfg.
+func NewConsulDiscovery(cfg *ConsulSDConfig, logger log.Logger) *ConsulDiscovery {
+	server := cfg.Server
+	if server == "" {
+		server = DefaultConsulServer
+	}
+	query := url.Values{}
+	if cfg.Datacenter != "" {
+		query.Set("dc", cfg.Datacenter)
+	}
+	for _, tag := range cfg.Tags {
+		query.Add("tag", tag)
+	}
+	u := strings.TrimSuffix(exporter.NormalizeAddress(server), "/") + "/v1/catalog/service/" + url.PathEscape(cfg.Service)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	interval := cfg.RefreshInterval
+	if interval == 0 {
+		interval = DefaultConsulSDRefreshInterval
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultConsulSDTimeout
+	}
+	return &ConsulDiscovery{
+		cfg:      *cfg,
+		url:      u,
+		interval: interval,
+		timeout:  timeout,
+		client:   exporter.NewHTTPClient(),
+		logger:   logger,
+		groups:   []*Group{},
+	}
+}
+
+// Run implements Discoverer.
+func (d *ConsulDiscovery) Run(ctx context.Context, up chan<- []*Group) {
+	poll(ctx, d.interval, up, d.refresh)
+}
+
+// refresh queries the catalog and returns a group for each service instance.
+func (d *ConsulDiscovery) refresh(ctx context.Context) []*Group {
+	services, err := d.catalogService(ctx)
+	if err != nil {
+		level.Error(d.logger).Log("msg", "Error querying consul catalog", "service", d.cfg.Service, "err", err)
+		return d.groups
+	}
+
+	groups := make([]*Group, 0, len(services))
+	for _, s := range services {
+		address := s.ServiceAddress
+		if address == "" {
+			address = s.Address
+		}
+		port := d.cfg.Port
+		if port == 0 {
+			port = s.ServicePort
+		}
+		if port == 0 {
+			level.Warn(d.logger).Log("msg", "Skipping consul service instance without port", "node", s.Node, "service_id", s.ServiceID)
+			continue
+		}
+
+		labels := map[string]string{
+			"consul_service": d.cfg.Service,
+			"consul_node":    s.Node,
+			"consul_dc":      s.Datacenter,
+		}
+		for k, v := range s.ServiceMeta {
+			labels["consul_meta_"+invalidLabelCharRE.ReplaceAllString(k, "_")] = v
+		}
+
+		groups = append(groups, &Group{
+			Source:  s.Node + "/" + s.ServiceID,
+			Targets: []string{net.JoinHostPort(address, strconv.Itoa(port))},
+			Labels:  labels,
+		})
+	}
+	d.groups = groups
+	return groups
+}
+
+// catalogService returns the instances of the service in the catalog.
+func (d *ConsulDiscovery) catalogService(ctx context.Context) ([]consulService, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", d.cfg.Token)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var services []consulService
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestConsulDiscoveryRefresh(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/catalog/service/dynomite" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.URL.Query()["tag"]; !reflect.DeepEqual(got, []string{"main"}) {
+			t.Errorf("tag = %v, want [main]", got)
+		}
+		if got := r.URL.Query().Get("dc"); got != "us-east-1" {
+			t.Errorf("dc = %q, want us-east-1", got)
+		}
+		if got := r.Header.Get("X-Consul-Token"); got != "secret" {
+			t.Errorf("X-Consul-Token = %q, want secret", got)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`[
+			{"Node": "node-1", "Address": "10.0.0.1", "Datacenter": "us-east-1",
+			 "ServiceID": "dynomite-1", "ServiceAddress": "10.0.1.1", "ServicePort": 8102,
+			 "ServiceMeta": {"rack": "rack-1", "ring-name": "main"}},
+			{"Node": "node-2", "Address": "10.0.0.2", "Datacenter": "us-east-1",
+			 "ServiceID": "dynomite-2", "ServicePort": 8102}
+		]`))
+	}))
+	defer srv.Close()
+
+	d := NewConsulDiscovery(&ConsulSDConfig{
+		Server:     srv.URL,
+		Token:      "secret",
+		Datacenter: "us-east-1",
+		Service:    "dynomite",
+		Tags:       []string{"main"},
+		Port:       22222,
+	}, log.NewNopLogger())
+
+	want := []*Group{
+		{
+			Source:  "node-1/dynomite-1",
+			Targets: []string{"10.0.1.1:22222"},
+			Labels: map[string]string{
+				"consul_service":        "dynomite",
+				"consul_node":           "node-1",
+				"consul_dc":             "us-east-1",
+				"consul_meta_rack":      "rack-1",
+				"consul_meta_ring_name": "main",
+			},
+		},
+		{
+			Source:  "node-2/dynomite-2",
+			Targets: []string{"10.0.0.2:22222"},
+			Labels: map[string]string{
+				"consul_service": "dynomite",
+				"consul_node":    "node-2",
+				"consul_dc":      "us-east-1",
+			},
+		},
+	}
+	if got := d.refresh(context.Background()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("refresh() = %v, want %v", got, want)
+	}
+
+	status = http.StatusInternalServerError
+	if got := d.refresh(context.Background()); !reflect.DeepEqual(got, want) {
+		t.Errorf("refresh() after error = %v, want the groups last found", got)
+	}
+}
+
+func TestConsulDiscoveryServicePort(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"Node": "node-1", "Address": "10.0.0.1", "ServiceID": "dynomite-1", "ServicePort": 22222},
+			{"Node": "node-2", "Address": "10.0.0.2", "ServiceID": "dynomite-2"}
+		]`))
+	}))
+	defer srv.Close()
+
+	d := NewConsulDiscovery(&ConsulSDConfig{Server: srv.URL, Service: "dynomite"}, log.NewNopLogger())
+	got := d.refresh(context.Background())
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Targets, []string{"10.0.0.1:22222"}) {
+		t.Errorf("refresh() = %v, want only node-1 at its service port", got)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/foxdalas/dynomite-exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/config"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Group is a set of dynomite nodes sharing labels, as in a Prometheus target
+// group.
+type Group struct {
+	// Source identifies the group within its discoverer.
+	Source string
+	// Targets are the addresses of the nodes.
+	Targets []string
+	Labels  map[string]string
+	// HTTPClientConfig, if not nil, configures TLS, authentication and the
+	// proxy for requesting the targets.
+	HTTPClientConfig *config.HTTPClientConfig
+}
+
+// Discoverer finds dynomite nodes to scrape.
+type Discoverer interface {
+	// Run sends the complete list of groups found on up whenever it
+	// changes, until ctx is done.
+	Run(ctx context.Context, up chan<- []*Group)
+}
+
+// WithHTTPClientConfig returns a discoverer reporting the groups of d with
+// cfg as their HTTP client configuration. d is returned as is if cfg is nil.
+func WithHTTPClientConfig(d Discoverer, cfg *config.HTTPClientConfig) Discoverer {
+	if cfg == nil {
+		return d
+	}
+	return &httpClientConfigDiscoverer{Discoverer: d, cfg: cfg}
+}
+
+type httpClientConfigDiscoverer struct {
+	Discoverer
+	cfg *config.HTTPClientConfig
+}
+
+func (d *httpClientConfigDiscoverer) Run(ctx context.Context, up chan<- []*Group) {
+	ch := make(chan []*Group)
+	go d.Discoverer.Run(ctx, ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case groups := <-ch:
+			// The groups may still be referenced by the discoverer.
+			copies := make([]*Group, 0, len(groups))
+			for _, g := range groups {
+				c := *g
+				c.HTTPClientConfig = d.cfg
+				copies = append(copies, &c)
+			}
+			select {
+			case up <- copies:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// validateHTTPClientConfig checks the HTTP client configuration cfg of a
+// discovery configuration of the given kind, if not nil.
+func validateHTTPClientConfig(kind string, cfg *config.HTTPClientConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if _, err := exporter.NewHTTPClientFromConfig(cfg); err != nil {
+		return fmt.Errorf("%s: %w", kind, err)
+	}
+	return nil
+}
+
+// Manager runs discoverers and scrapes the nodes they find, together with
+// the statically configured targets, with a TargetCollector.
+type Manager struct {
+	targets *exporter.TargetCollector
+	logger  log.Logger
+
+	mtx    sync.Mutex
+	cancel context.CancelFunc
+	static []exporter.Target
+	groups map[string][]*Group
+}
+
+// NewManager returns a manager updating the targets of targets.
+func NewManager(targets *exporter.TargetCollector, logger log.Logger) *Manager {
+	return &Manager{
+		targets: targets,
+		logger:  logger,
+		cancel:  func() {},
+		groups:  make(map[string][]*Group),
+	}
+}
+
+// ApplyConfig stops the running discoverers and replaces the static targets
+// and the discoverers, keyed by a unique name. The targets found by a previous
+// discoverer are kept until the new discoverer of the same name reports its
+// own, so that reloading an unchanged configuration keeps the exporters of
+// discovered targets and their state. Those of discoverers no longer
+// configured are dropped.
+func (m *Manager) ApplyConfig(static []exporter.Target, discoverers map[string]Discoverer) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.cancel()
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.static = static
+	groups := make(map[string][]*Group, len(discoverers))
+	for name := range discoverers {
+		if g, ok := m.groups[name]; ok {
+			groups[name] = g
+		}
+	}
+	m.groups = groups
+	m.updateTargets()
+
+	for name, d := range discoverers {
+		up := make(chan []*Group)
+		go d.Run(ctx, up)
+		go m.receive(ctx, name, up)
+	}
+}
+
+// Stop stops the running discoverers.
+func (m *Manager) Stop() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.cancel()
+}
+
+func (m *Manager) receive(ctx context.Context, name string, up <-chan []*Group) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case groups := <-up:
+			m.mtx.Lock()
+			// A discoverer of a replaced configuration may still deliver
+			// its last update.
+			if ctx.Err() == nil {
+				m.groups[name] = groups
+				m.updateTargets()
+			}
+			m.mtx.Unlock()
+		}
+	}
+}
+
+// reservedLabelPrefix is prepended to discovered labels whose name is used by
+// the metrics of the exporter.
+const reservedLabelPrefix = "sd_"
+
+// updateTargets hands the static and discovered targets to the collector.
+// A node found more than once is scraped once, with the labels of the
+// static target or of the first group it was found in. Labels starting with
+// __ are dropped, and those named like a label of the exporter's metrics are
+// prefixed with sd_.
+func (m *Manager) updateTargets() {
+	targets := append([]exporter.Target{}, m.static...)
+	seen := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		seen[t.Name] = true
+	}
+
+	names := make([]string, 0, len(m.groups))
+	for name := range m.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, g := range m.groups[name] {
+			labels := make(map[string]string, len(g.Labels))
+			for k, v := range g.Labels {
+				switch {
+				case strings.HasPrefix(k, "__"):
+				case exporter.ReservedLabel(k):
+					labels[reservedLabelPrefix+k] = v
+				default:
+					labels[k] = v
+				}
+			}
+
+			for _, address := range g.Targets {
+				if seen[address] {
+					continue
+				}
+				t := exporter.Target{
+					Name:             address,
+					Address:          exporter.NormalizeAddress(address),
+					Labels:           labels,
+					HTTPClientConfig: g.HTTPClientConfig,
+				}
+				if err := t.Validate(); err != nil {
+					level.Warn(m.logger).Log("msg", "Dropping discovered target", "discoverer", name, "source", g.Source, "err", err)
+					continue
+				}
+				seen[address] = true
+				targets = append(targets, t)
+			}
+		}
+	}
+
+	m.targets.SetTargets(targets)
+}
+
+// poll sends the groups returned by refresh on up every interval, skipping
+// those equal to the ones last sent, until ctx is done.
+func poll(ctx context.Context, interval time.Duration, up chan<- []*Group, refresh func(context.Context) []*Group) {
+	pollOrTrigger(ctx, interval, nil, up, refresh)
+}
+
+// pollOrTrigger is poll, refreshing also whenever trigger receives.
+func pollOrTrigger(ctx context.Context, interval time.Duration, trigger <-chan struct{}, up chan<- []*Group, refresh func(context.Context) []*Group) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []*Group
+	for {
+		groups := refresh(ctx)
+		if last == nil || !reflect.DeepEqual(groups, last) {
+			select {
+			case up <- groups:
+				last = groups
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-trigger:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"github.com/foxdalas/dynomite-exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// chanDiscoverer sends the groups received on its channel.
+type chanDiscoverer chan []*Group
+
+func (d chanDiscoverer) Run(ctx context.Context, up chan<- []*Group) {
+	for {
+		select {
+		case groups := <-d:
+			select {
+			case up <- groups:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func newTestManager() (*Manager, *exporter.TargetCollector) {
+	targets := exporter.NewTargetCollector(exporter.Options{Client: exporter.NewHTTPClient(), Timeout: time.Second}, log.NewNopLogger())
+	return NewManager(targets, log.NewNopLogger()), targets
+}
+
+// waitForTarget waits for the target named name to be present or absent.
+func waitForTarget(t *testing.T, targets *exporter.TargetCollector, name string, present bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := targets.Target(name); ok == present {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("target %s present = %v, want %v", name, !present, present)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManagerApplyConfigKeepsDiscoveredTargets(t *testing.T) {
+	m, targets := newTestManager()
+	defer m.Stop()
+
+	first := make(chanDiscoverer)
+	m.ApplyConfig(nil, map[string]Discoverer{"file_sd/0": first, "dns_sd/0": make(chanDiscoverer)})
+	first <- []*Group{{Targets: []string{"dynomite-1:22222"}}}
+	waitForTarget(t, targets, "dynomite-1:22222", true)
+
+	// The target is kept while the reloaded discoverer has not reported.
+	second := make(chanDiscoverer)
+	m.ApplyConfig([]exporter.Target{{Name: "static", Address: "static:22222"}}, map[string]Discoverer{"file_sd/0": second})
+	if _, ok := targets.Target("dynomite-1:22222"); !ok {
+		t.Fatal("discovered target dropped on reload")
+	}
+	if _, ok := targets.Target("static"); !ok {
+		t.Fatal("static target not added on reload")
+	}
+
+	// The last update of the replaced discoverer is ignored.
+	select {
+	case first <- []*Group{{Targets: []string{"dynomite-9:22222"}}}:
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	second <- []*Group{{Targets: []string{"dynomite-2:22222"}}}
+	waitForTarget(t, targets, "dynomite-2:22222", true)
+	waitForTarget(t, targets, "dynomite-1:22222", false)
+	if _, ok := targets.Target("dynomite-9:22222"); ok {
+		t.Error("target of a replaced discoverer added")
+	}
+
+	// Targets of a discoverer no longer configured are dropped right away.
+	m.ApplyConfig(nil, map[string]Discoverer{})
+	if _, ok := targets.Target("dynomite-2:22222"); ok {
+		t.Error("target of a removed discoverer kept")
+	}
+}
+
+func TestManagerUpdateTargetsLabels(t *testing.T) {
+	m, targets := newTestManager()
+	m.static = []exporter.Target{{Name: "dynomite-1:22222", Address: "dynomite-1:22222", Labels: map[string]string{"env": "static"}}}
+	m.groups = map[string][]*Group{
+		"file_sd/0": {{
+			Targets: []string{"dynomite-1:22222", "dynomite-2:22222"},
+			Labels: map[string]string{
+				"env":          "prod",
+				"dc":           "us-east-1",
+				"rack":         "rack-1",
+				"__meta_model": "dropped",
+			},
+		}},
+	}
+	m.updateTargets()
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+	}{
+		{"dynomite-1:22222", map[string]string{"env": "static"}},
+		{"dynomite-2:22222", map[string]string{"env": "prod", "sd_dc": "us-east-1", "sd_rack": "rack-1"}},
+	}
+	for _, tt := range tests {
+		target, ok := targets.Target(tt.name)
+		if !ok {
+			t.Errorf("target %s missing", tt.name)
+			continue
+		}
+		if !reflect.DeepEqual(target.Labels, tt.labels) {
+			t.Errorf("labels of %s = %v, want %v", tt.name, target.Labels, tt.labels)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/config"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNSSDConfig configures the discovery of dynomite nodes by resolving DNS
+// names.
+type DNSSDConfig struct {
+	Names []string `yaml:"names"`
+	// Type is the record type queried: SRV (the default), A or AAAA.
+	Type string `yaml:"type,omitempty"`
+	// Port is the admin port of the nodes found. It is required for A and
+	// AAAA queries, and overrides the port of SRV records when set.
+	Port int `yaml:"port,omitempty"`
+	// RefreshInterval is how often the names are resolved.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+	// HTTPClientConfig configures TLS, authentication and the proxy for
+	// requesting the stats endpoints of the nodes found.
+	HTTPClientConfig *config.HTTPClientConfig `yaml:"http_client_config,omitempty"`
+}
+
+// DefaultDNSSDRefreshInterval is the refresh interval of a DNSSDConfig not
+// setting one.
+const DefaultDNSSDRefreshInterval = 30 * time.Second
+
+// Validate checks the configuration.
+func (c *DNSSDConfig) Validate() error {
+	if len(c.Names) == 0 {
+		return fmt.Errorf("dns_sd_config has no names")
+	}
+	switch strings.ToUpper(c.Type) {
+	case "", "SRV":
+		if c.Port < 0 || c.Port > 65535 {
+			return fmt.Errorf("dns_sd_config: invalid port %d", c.Port)
+		}
+	case "A", "AAAA":
+		if c.Port <= 0 || c.Port > 65535 {
+			return fmt.Errorf("dns_sd_config: a port is required for %s queries", strings.ToUpper(c.Type))
+		}
+	default:
+		return fmt.Errorf("dns_sd_config: invalid type %q", c.Type)
+	}
+	return validateHTTPClientConfig("dns_sd_config", c.HTTPClientConfig)
+}
+
+// resolver is the part of net.Resolver used by DNSDiscovery.
+type resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DNSDiscovery finds dynomite nodes by resolving SRV, A or AAAA records
+// every refresh interval.
+type DNSDiscovery struct {
+	names    []string
+	qtype    string
+	port     int
+	interval time.Duration
+	resolver resolver
+	logger   log.Logger
+
+	// groups holds the groups last resolved for each name, which are kept
+	// while the name cannot be resolved.
+	groups map[string]*Group
+}
+
+// NewDNSDiscovery returns a discoverer for cfg using the default resolver.
+func NewDNSDiscovery(cfg *DNSSDConfig, logger log.Logger) *DNSDiscovery {
+	qtype := strings.ToUpper(cfg.Type)
+	if qtype == "" {
+		qtype = "SRV"
+	}
+	interval := cfg.RefreshInterval
+	if interval == 0 {
+		interval = DefaultDNSSDRefreshInterval
+	}
+	return &DNSDiscovery{
+		names:    cfg.Names,
+		qtype:    qtype,
+		port:     cfg.Port,
+		interval: interval,
+		resolver: net.DefaultResolver,
+		logger:   logger,
+		groups:   make(map[string]*Group),
+	}
+}
+
+// Run implements Discoverer.
+func (d *DNSDiscovery) Run(ctx context.Context, up chan<- []*Group) {
+	poll(ctx, d.interval, up, d.refresh)
+}
+
+// refresh resolves all names and returns a group for each of them.
+func (d *DNSDiscovery) refresh(ctx context.Context) []*Group {
+	groups := make([]*Group, 0, len(d.names))
+	for _, name := range d.names {
+		targets, err := d.resolve(ctx, name)
+		if err != nil {
+			level.Error(d.logger).Log("msg", "Error resolving dns_sd name", "name", name, "type", d.qtype, "err", err)
+		} else {
+			d.groups[name] = &Group{
+				Source:  name,
+				Targets: targets,
+				Labels:  map[string]string{"dns_name": name},
+			}
+		}
+		if g, ok := d.groups[name]; ok {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// resolve returns the addresses name resolves to, as host:port.
+func (d *DNSDiscovery) resolve(ctx context.Context, name string) ([]string, error) {
+	var targets []string
+	switch d.qtype {
+	case "SRV":
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			host := strings.TrimSuffix(r.Target, ".")
+			port := int(r.Port)
+			if d.port != 0 {
+				port = d.port
+			}
+			targets = append(targets, net.JoinHostPort(host, strconv.Itoa(port)))
+		}
+	default:
+		addrs, err := d.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		port := strconv.Itoa(d.port)
+		for _, addr := range addrs {
+			if (addr.IP.To4() != nil) != (d.qtype == "A") {
+				continue
+			}
+			targets = append(targets, net.JoinHostPort(addr.IP.String(), port))
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type stubResolver struct {
+	srvs  []*net.SRV
+	addrs []net.IPAddr
+	err   error
+}
+
+func (r stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return "", r.srvs, r.err
+}
+
+func (r stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r.addrs, r.err
+}
+
+func TestDNSDiscoveryResolve(t *testing.T) {
+	addrs := []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("2001:db8::1")},
+		{IP: net.ParseIP("10.0.0.2")},
+	}
+	tests := []struct {
+		name     string
+		cfg      DNSSDConfig
+		resolver stubResolver
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "srv",
+			cfg:  DNSSDConfig{Names: []string{"_dynomite._tcp.example.com"}},
+			resolver: stubResolver{srvs: []*net.SRV{
+				{Target: "dynomite-1.example.com.", Port: 22222},
+				{Target: "dynomite-2.example.com", Port: 22223},
+			}},
+			want: []string{"dynomite-1.example.com:22222", "dynomite-2.example.com:22223"},
+		},
+		{
+			name: "srv with port",
+			cfg:  DNSSDConfig{Names: []string{"_dynomite._tcp.example.com"}, Port: 22224},
+			resolver: stubResolver{srvs: []*net.SRV{
+				{Target: "dynomite-1.example.com.", Port: 8101},
+				{Target: "dynomite-2.example.com", Port: 8102},
+			}},
+			want: []string{"dynomite-1.example.com:22224", "dynomite-2.example.com:22224"},
+		},
+		{
+			name:     "a",
+			cfg:      DNSSDConfig{Names: []string{"dynomite.example.com"}, Type: "A", Port: 22222},
+			resolver: stubResolver{addrs: addrs},
+			want:     []string{"10.0.0.1:22222", "10.0.0.2:22222"},
+		},
+		{
+			name:     "aaaa",
+			cfg:      DNSSDConfig{Names: []string{"dynomite.example.com"}, Type: "AAAA", Port: 22222},
+			resolver: stubResolver{addrs: addrs},
+			want:     []string{"[2001:db8::1]:22222"},
+		},
+		{
+			name:     "no records",
+			cfg:      DNSSDConfig{Names: []string{"dynomite.example.com"}, Type: "A", Port: 22222},
+			resolver: stubResolver{},
+			want:     nil,
+		},
+		{
+			name:     "error",
+			cfg:      DNSSDConfig{Names: []string{"_dynomite._tcp.example.com"}},
+			resolver: stubResolver{err: errors.New("no such host")},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDNSDiscovery(&tt.cfg, log.NewNopLogger())
+			d.resolver = tt.resolver
+			got, err := d.resolve(context.Background(), tt.cfg.Names[0])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSDiscoveryRefreshKeepsTargetsOnError(t *testing.T) {
+	cfg := DNSSDConfig{Names: []string{"dynomite.example.com"}, Type: "A", Port: 22222}
+	d := NewDNSDiscovery(&cfg, log.NewNopLogger())
+	d.resolver = stubResolver{addrs: []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}}
+	d.refresh(context.Background())
+
+	d.resolver = stubResolver{err: errors.New("timeout")}
+	groups := d.refresh(context.Background())
+	if len(groups) != 1 || !reflect.DeepEqual(groups[0].Targets, []string{"10.0.0.1:22222"}) {
+		t.Fatalf("refresh() after error = %v, want the targets last resolved", groups)
+	}
+	if groups[0].Labels["dns_name"] != "dynomite.example.com" {
+		t.Errorf("dns_name label = %q", groups[0].Labels["dns_name"])
+	}
+}
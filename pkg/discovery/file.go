@@ -0,0 +1,217 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/config"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileSDConfig configures the discovery of dynomite nodes listed in files in
+// the Prometheus file_sd format.
+type FileSDConfig struct {
+	// Files are glob patterns of JSON (.json) or YAML (.yml, .yaml) files.
+	Files []string `yaml:"files"`
+	// RefreshInterval is how often the files are reread, in case a change
+	// was not noticed by watching them.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+	// HTTPClientConfig configures TLS, authentication and the proxy for
+	// requesting the stats endpoints of the nodes found.
+	HTTPClientConfig *config.HTTPClientConfig `yaml:"http_client_config,omitempty"`
+}
+
+// DefaultFileSDRefreshInterval is the refresh interval of a FileSDConfig not
+// setting one.
+const DefaultFileSDRefreshInterval = 30 * time.Second
+
+// Validate checks the configuration.
+func (c *FileSDConfig) Validate() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf("file_sd_config has no files")
+	}
+	for _, pattern := range c.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("file_sd_config: invalid pattern %q: %w", pattern, err)
+		}
+		switch filepath.Ext(pattern) {
+		case ".json", ".yml", ".yaml":
+		default:
+			return fmt.Errorf("file_sd_config: pattern %q must end in .json, .yml or .yaml", pattern)
+		}
+	}
+	return validateHTTPClientConfig("file_sd_config", c.HTTPClientConfig)
+}
+
+// fileGroup is a target group as written in a file_sd file.
+type fileGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// FileDiscovery finds dynomite nodes listed in file_sd files, rereading the
+// files matching its patterns whenever their directories change, and every
+// refresh interval in case a change was missed.
+type FileDiscovery struct {
+	patterns []string
+	interval time.Duration
+	logger   log.Logger
+
+	// groups holds the groups last read from each file, which are kept
+	// while the file cannot be read or parsed.
+	groups map[string][]*Group
+}
+
+// NewFileDiscovery returns a discoverer for cfg.
+func NewFileDiscovery(cfg *FileSDConfig, logger log.Logger) *FileDiscovery {
+	interval := cfg.RefreshInterval
+	if interval == 0 {
+		interval = DefaultFileSDRefreshInterval
+	}
+	return &FileDiscovery{
+		patterns: cfg.Files,
+		interval: interval,
+		logger:   logger,
+		groups:   make(map[string][]*Group),
+	}
+}
+
+// Run implements Discoverer.
+func (d *FileDiscovery) Run(ctx context.Context, up chan<- []*Group) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		level.Error(d.logger).Log("msg", "Error creating file watcher, only rereading file_sd files every refresh interval", "err", err)
+		poll(ctx, d.interval, up, d.refresh)
+		return
+	}
+	defer watcher.Close()
+
+	// The directories are watched rather than the files, which are
+	// typically replaced rather than written to, and may not exist yet.
+	dirs := make(map[string]bool)
+	for _, pattern := range d.patterns {
+		dir := filepath.Dir(pattern)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		if err := watcher.Add(dir); err != nil {
+			level.Error(d.logger).Log("msg", "Error watching file_sd directory", "dir", dir, "err", err)
+		}
+	}
+
+	trigger := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				select {
+				case trigger <- struct{}{}:
+				default:
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				level.Error(d.logger).Log("msg", "Error watching file_sd files", "err", err)
+			}
+		}
+	}()
+
+	pollOrTrigger(ctx, d.interval, trigger, up, d.refresh)
+}
+
+// refresh rereads the files matching the patterns and returns the groups of
+// all of them.
+func (d *FileDiscovery) refresh(ctx context.Context) []*Group {
+	files := make(map[string]bool)
+	for _, pattern := range d.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			level.Error(d.logger).Log("msg", "Error expanding file_sd pattern", "pattern", pattern, "err", err)
+			continue
+		}
+		for _, f := range matches {
+			files[f] = true
+		}
+	}
+
+	for f := range d.groups {
+		if !files[f] {
+			delete(d.groups, f)
+		}
+	}
+	for f := range files {
+		groups, err := readFileGroups(f)
+		if err != nil {
+			level.Error(d.logger).Log("msg", "Error reading file_sd file", "file", f, "err", err)
+			continue
+		}
+		d.groups[f] = groups
+	}
+
+	names := make([]string, 0, len(d.groups))
+	for f := range d.groups {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+
+	all := []*Group{}
+	for _, f := range names {
+		all = append(all, d.groups[f]...)
+	}
+	return all
+}
+
+// readFileGroups parses the file_sd file filename.
+func readFileGroups(filename string) ([]*Group, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var fgs []fileGroup
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		err = json.Unmarshal(content, &fgs)
+	default:
+		err = yaml.UnmarshalStrict(content, &fgs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*Group, 0, len(fgs))
+	for i, fg := range fgs {
+		groups = append(groups, &Group{
+			Source:  fmt.Sprintf("%s:%d", filename, i),
+			Targets: fg.Targets,
+			Labels:  fg.Labels,
+		})
+	}
+	return groups, nil
+}
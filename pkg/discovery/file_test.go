@@ -0,0 +1,123 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, filename, content string) {
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadFileGroups(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		file    string
+		content string
+		want    []*Group
+		wantErr bool
+	}{
+		{
+			file:    "targets.json",
+			content: `[{"targets": ["dynomite-1:22222", "dynomite-2:22222"], "labels": {"cluster": "main"}}, {"targets": ["dynomite-3:22222"]}]`,
+			want: []*Group{
+				{Targets: []string{"dynomite-1:22222", "dynomite-2:22222"}, Labels: map[string]string{"cluster": "main"}},
+				{Targets: []string{"dynomite-3:22222"}},
+			},
+		},
+		{
+			file:    "targets.yml",
+			content: "- targets: [dynomite-1:22222]\n  labels:\n    cluster: main\n",
+			want: []*Group{
+				{Targets: []string{"dynomite-1:22222"}, Labels: map[string]string{"cluster": "main"}},
+			},
+		},
+		{
+			file:    "unknown.yaml",
+			content: "- targets: [dynomite-1:22222]\n  label: {cluster: main}\n",
+			wantErr: true,
+		},
+		{
+			file:    "invalid.json",
+			content: `[{"targets": ["dynomite-1:22222"]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			filename := filepath.Join(dir, tt.file)
+			writeFile(t, filename, tt.content)
+			got, err := readFileGroups(filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readFileGroups() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for i, g := range tt.want {
+				g.Source = fmt.Sprintf("%s:%d", filename, i)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readFileGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileDiscoveryRefresh(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.yml")
+	writeFile(t, a, `[{"targets": ["dynomite-1:22222"]}]`)
+	writeFile(t, b, "- targets: [dynomite-2:22222]\n")
+
+	d := NewFileDiscovery(&FileSDConfig{Files: []string{filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yml")}}, log.NewNopLogger())
+	targets := func() []string {
+		var targets []string
+		for _, g := range d.refresh(context.Background()) {
+			targets = append(targets, g.Targets...)
+		}
+		return targets
+	}
+	if got, want := targets(), []string{"dynomite-1:22222", "dynomite-2:22222"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("targets = %v, want %v", got, want)
+	}
+
+	// A file that can no longer be parsed or read keeps its targets.
+	writeFile(t, a, `[{"targets": `)
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(b, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := targets(), []string{"dynomite-1:22222", "dynomite-2:22222"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("targets after errors = %v, want %v", got, want)
+	}
+
+	// A file removed drops its targets.
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, a, `[{"targets": ["dynomite-3:22222"]}]`)
+	if got, want := targets(), []string{"dynomite-3:22222"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("targets after changes = %v, want %v", got, want)
+	}
+}
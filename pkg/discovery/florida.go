@@ -0,0 +1,191 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/foxdalas/dynomite-exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/config"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FloridaSDConfig configures the discovery of dynomite nodes from a seed
+// provider serving the format of dynomite-manager (Florida).
+type FloridaSDConfig struct {
+	URL string `yaml:"url,omitempty"`
+	// Port is the admin port of the nodes. The ports of the seeds are the
+	// peer ports.
+	Port int `yaml:"port"`
+	// RefreshInterval is how often the seeds are fetched.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+	// Timeout is the timeout of fetching the seeds.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// HTTPClientConfig configures TLS, authentication and the proxy for
+	// requesting the stats endpoints of the nodes found.
+	HTTPClientConfig *config.HTTPClientConfig `yaml:"http_client_config,omitempty"`
+}
+
+const (
+	// DefaultFloridaURL is the seed provider of a FloridaSDConfig not
+	// setting one, the default of dynomite's florida seed provider.
+	DefaultFloridaURL = "http://127.0.0.1:8080/REST/v1/admin/get_seeds"
+	// DefaultFloridaSDRefreshInterval is the refresh interval of a
+	// FloridaSDConfig not setting one.
+	DefaultFloridaSDRefreshInterval = 30 * time.Second
+	// DefaultFloridaSDTimeout is the timeout of a FloridaSDConfig not
+	// setting one.
+	DefaultFloridaSDTimeout = 5 * time.Second
+
+	// floridaResponseSize bounds the seed list read.
+	floridaResponseSize = 1 << 20
+)
+
+// Validate checks the configuration.
+func (c *FloridaSDConfig) Validate() error {
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("florida_sd_config: a port is required")
+	}
+	return validateHTTPClientConfig("florida_sd_config", c.HTTPClientConfig)
+}
+
+// FloridaDiscovery finds dynomite nodes from a seed provider every refresh
+// interval.
+type FloridaDiscovery struct {
+	url      string
+	port     int
+	interval time.Duration
+	timeout  time.Duration
+	client   *http.Client
+	logger   log.Logger
+
+	// groups holds the groups last found, which are kept while the seed
+	// provider fails.
+	groups []*Group
+}
+
+// NewFloridaDiscovery returns a discoverer for cfg.
+func NewFloridaDiscovery(cfg *FloridaSDConfig, logger log.Logger) *FloridaDiscovery {
+	u := cfg.URL
+	if u == "" {
+		u = DefaultFloridaURL
+	}
+	interval := cfg.RefreshInterval
+	if interval == 0 {
+		interval = DefaultFloridaSDRefreshInterval
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultFloridaSDTimeout
+	}
+	return &FloridaDiscovery{
+		url:      exporter.NormalizeAddress(u),
+		port:     cfg.Port,
+		interval: interval,
+		timeout:  timeout,
+		client:   exporter.NewHTTPClient(),
+		logger:   logger,
+		groups:   []*Group{},
+	}
+}
+
+// Run implements Discoverer.
+func (d *FloridaDiscovery) Run(ctx context.Context, up chan<- []*Group) {
+	poll(ctx, d.interval, up, d.refresh)
+}
+
+// refresh fetches the seeds and returns a group for each of them.
+func (d *FloridaDiscovery) refresh(ctx context.Context) []*Group {
+	body, err := d.fetch(ctx)
+	if err != nil {
+		level.Error(d.logger).Log("msg", "Error fetching seeds", "url", d.url, "err", err)
+		return d.groups
+	}
+
+	groups := []*Group{}
+	for _, s := range strings.FieldsFunc(string(body), func(r rune) bool {
+		return r == '|' || r == '\n' || r == '\r'
+	}) {
+		seed, err := parseFloridaSeed(strings.TrimSpace(s))
+		if err != nil {
+			level.Warn(d.logger).Log("msg", "Skipping invalid seed", "seed", s, "err", err)
+			continue
+		}
+		groups = append(groups, &Group{
+			Source:  s,
+			Targets: []string{net.JoinHostPort(seed.host, strconv.Itoa(d.port))},
+			Labels: map[string]string{
+				"ring_dc":    seed.dc,
+				"ring_rack":  seed.rack,
+				"ring_token": seed.token,
+			},
+		})
+	}
+	d.groups = groups
+	return groups
+}
+
+// fetch returns the seed list served by the seed provider.
+func (d *FloridaDiscovery) fetch(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, floridaResponseSize))
+}
+
+// floridaSeed is a seed as served by the seed provider.
+type floridaSeed struct {
+	host, port, rack, dc, token string
+}
+
+// parseFloridaSeed parses a host:port:rack:dc:token seed. The host may be an
+// IPv6 address, so the fields are taken from the right.
+func parseFloridaSeed(s string) (floridaSeed, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) < 5 {
+		return floridaSeed{}, fmt.Errorf("expected host:port:rack:dc:token")
+	}
+	n := len(fields)
+	seed := floridaSeed{
+		host:  strings.Trim(strings.Join(fields[:n-4], ":"), "[]"),
+		port:  fields[n-4],
+		rack:  fields[n-3],
+		dc:    fields[n-2],
+		token: fields[n-1],
+	}
+	if seed.host == "" {
+		return floridaSeed{}, fmt.Errorf("empty host")
+	}
+	return seed, nil
+}
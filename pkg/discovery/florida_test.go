@@ -0,0 +1,56 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"testing"
+)
+
+func TestParseFloridaSeed(t *testing.T) {
+	tests := []struct {
+		seed    string
+		want    floridaSeed
+		wantErr bool
+	}{
+		{
+			seed: "dynomite-1:8101:rack-1:us-east-1:1383429731",
+			want: floridaSeed{host: "dynomite-1", port: "8101", rack: "rack-1", dc: "us-east-1", token: "1383429731"},
+		},
+		{
+			seed: "10.0.0.1:8101:rack-1:us-east-1:0",
+			want: floridaSeed{host: "10.0.0.1", port: "8101", rack: "rack-1", dc: "us-east-1", token: "0"},
+		},
+		{
+			seed: "2001:db8::1:8101:rack-1:us-east-1:0",
+			want: floridaSeed{host: "2001:db8::1", port: "8101", rack: "rack-1", dc: "us-east-1", token: "0"},
+		},
+		{
+			seed: "[2001:db8::1]:8101:rack-1:us-east-1:0",
+			want: floridaSeed{host: "2001:db8::1", port: "8101", rack: "rack-1", dc: "us-east-1", token: "0"},
+		},
+		{seed: "dynomite-1:8101:rack-1:us-east-1", wantErr: true},
+		{seed: ":8101:rack-1:us-east-1:0", wantErr: true},
+		{seed: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFloridaSeed(tt.seed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFloridaSeed(%q) error = %v, wantErr %v", tt.seed, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseFloridaSeed(%q) = %+v, want %+v", tt.seed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/foxdalas/dynomite-exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/config"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KubernetesSDConfig configures the discovery of dynomite nodes running as
+// Kubernetes pods.
+type KubernetesSDConfig struct {
+	// APIServer is the address of the Kubernetes API. It defaults to the
+	// API of the cluster the exporter runs in, authenticating with the
+	// service account of its pod.
+	APIServer string `yaml:"api_server,omitempty"`
+	// Namespace defaults to the namespace of the exporter's pod.
+	Namespace     string `yaml:"namespace,omitempty"`
+	LabelSelector string `yaml:"label_selector,omitempty"`
+	// Port is the admin port of the pods.
+	Port int `yaml:"port"`
+	// HTTPClientConfig configures TLS, authentication and the proxy for
+	// requesting the stats endpoints of the nodes found.
+	HTTPClientConfig *config.HTTPClientConfig `yaml:"http_client_config,omitempty"`
+}
+
+// Validate checks the configuration.
+func (c *KubernetesSDConfig) Validate() error {
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("kubernetes_sd_config: a port is required")
+	}
+	return validateHTTPClientConfig("kubernetes_sd_config", c.HTTPClientConfig)
+}
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// kubernetesRetryInterval is the time waited before listing the pods
+	// again after an error.
+	kubernetesRetryInterval = 5 * time.Second
+	// kubernetesWatchTimeout bounds a watch, after which the pods are
+	// listed again.
+	kubernetesWatchTimeout = 5 * time.Minute
+	// kubernetesRequestTimeout bounds listing the pods, and connecting to
+	// the API server.
+	kubernetesRequestTimeout = 30 * time.Second
+)
+
+type podMetadata struct {
+	Name              string  `json:"name"`
+	Namespace         string  `json:"namespace"`
+	DeletionTimestamp *string `json:"deletionTimestamp"`
+}
+
+type pod struct {
+	Metadata podMetadata `json:"metadata"`
+	Status   struct {
+		Phase string `json:"phase"`
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+type podList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []pod `json:"items"`
+}
+
+type podWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// KubernetesDiscovery finds dynomite nodes by listing the pods matching a
+// label selector, then watching them for changes.
+type KubernetesDiscovery struct {
+	cfg    KubernetesSDConfig
+	logger log.Logger
+
+	client    *http.Client
+	server    string
+	tokenFile string
+
+	// pods holds the group of every running pod by name.
+	pods map[string]*Group
+}
+
+// NewKubernetesDiscovery returns a discoverer for cfg.
+func NewKubernetesDiscovery(cfg *KubernetesSDConfig, logger log.Logger) *KubernetesDiscovery {
+	return &KubernetesDiscovery{
+		cfg:    *cfg,
+		logger: logger,
+		pods:   make(map[string]*Group),
+	}
+}
+
+// Run implements Discoverer.
+func (d *KubernetesDiscovery) Run(ctx context.Context, up chan<- []*Group) {
+	for {
+		err := d.connect()
+		if err == nil {
+			err = d.listAndWatch(ctx, up)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			level.Error(d.logger).Log("msg", "Error discovering kubernetes pods", "namespace", d.cfg.Namespace, "err", err)
+			select {
+			case <-time.After(kubernetesRetryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// connect sets up the client of the API server, once.
+func (d *KubernetesDiscovery) connect() error {
+	if d.client != nil {
+		return nil
+	}
+
+	if d.cfg.APIServer != "" {
+		d.server = strings.TrimSuffix(exporter.NormalizeAddress(d.cfg.APIServer), "/")
+		if d.cfg.Namespace == "" {
+			d.cfg.Namespace = "default"
+		}
+		d.client = &http.Client{Transport: newKubernetesTransport(nil)}
+		return nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return fmt.Errorf("not running in a kubernetes cluster and no api_server configured")
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("no certificates in %s/ca.crt", serviceAccountDir)
+	}
+	if d.cfg.Namespace == "" {
+		namespace, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return err
+		}
+		d.cfg.Namespace = strings.TrimSpace(string(namespace))
+	}
+
+	d.server = "https://" + net.JoinHostPort(host, port)
+	d.tokenFile = serviceAccountDir + "/token"
+	d.client = &http.Client{Transport: newKubernetesTransport(&tls.Config{RootCAs: pool})}
+	return nil
+}
+
+// newKubernetesTransport returns a transport for the API server, giving up on
+// one that does not accept connections or answer in time.
+func newKubernetesTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   kubernetesRequestTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   kubernetesRequestTimeout,
+		ResponseHeaderTimeout: kubernetesRequestTimeout,
+	}
+}
+
+// listAndWatch lists the pods and sends their groups, then watches them and
+// sends the updated groups on every change, until the watch ends.
+func (d *KubernetesDiscovery) listAndWatch(ctx context.Context, up chan<- []*Group) error {
+	var list podList
+	listCtx, cancel := context.WithTimeout(ctx, kubernetesRequestTimeout)
+	defer cancel()
+	resp, err := d.get(listCtx, url.Values{})
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	d.pods = make(map[string]*Group, len(list.Items))
+	for _, p := range list.Items {
+		d.update(p)
+	}
+	if !d.send(ctx, up) {
+		return nil
+	}
+
+	// The API server ends the watch after its timeout, a watch outliving it
+	// is hung.
+	watchCtx, cancel := context.WithTimeout(ctx, kubernetesWatchTimeout+kubernetesRequestTimeout)
+	defer cancel()
+	resp, err = d.get(watchCtx, url.Values{
+		"watch":           {"1"},
+		"resourceVersion": {list.Metadata.ResourceVersion},
+		"timeoutSeconds":  {strconv.Itoa(int(kubernetesWatchTimeout.Seconds()))},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event podWatchEvent
+		if err := dec.Decode(&event); err == io.EOF {
+			// The API server ended the watch after its timeout, the pods
+			// are listed again.
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading watch: %w", err)
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			var p pod
+			if err := json.Unmarshal(event.Object, &p); err != nil {
+				return err
+			}
+			if event.Type == "DELETED" {
+				delete(d.pods, p.Metadata.Name)
+			} else {
+				d.update(p)
+			}
+		case "ERROR":
+			// Typically the resource version being too old, the pods are
+			// listed again.
+			return fmt.Errorf("watch error: %s", event.Object)
+		default:
+			continue
+		}
+		if !d.send(ctx, up) {
+			return nil
+		}
+	}
+}
+
+// get requests the pods of the namespace matching the label selector.
+func (d *KubernetesDiscovery) get(ctx context.Context, query url.Values) (*http.Response, error) {
+	if d.cfg.LabelSelector != "" {
+		query.Set("labelSelector", d.cfg.LabelSelector)
+	}
+	u := d.server + "/api/v1/namespaces/" + url.PathEscape(d.cfg.Namespace) + "/pods?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.tokenFile != "" {
+		// Service account tokens are rotated, so the file is read anew.
+		token, err := ioutil.ReadFile(d.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// update records the group of p, or forgets p if it is not running.
+func (d *KubernetesDiscovery) update(p pod) {
+	if p.Status.Phase != "Running" || p.Status.PodIP == "" || p.Metadata.DeletionTimestamp != nil {
+		delete(d.pods, p.Metadata.Name)
+		return
+	}
+	d.pods[p.Metadata.Name] = &Group{
+		Source:  p.Metadata.Namespace + "/" + p.Metadata.Name,
+		Targets: []string{net.JoinHostPort(p.Status.PodIP, strconv.Itoa(d.cfg.Port))},
+		Labels: map[string]string{
+			"namespace": p.Metadata.Namespace,
+			"pod":       p.Metadata.Name,
+		},
+	}
+}
+
+// send sends the groups of all pods on up. It returns false if ctx is done
+// first.
+func (d *KubernetesDiscovery) send(ctx context.Context, up chan<- []*Group) bool {
+	names := make([]string, 0, len(d.pods))
+	for name := range d.pods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	groups := make([]*Group, 0, len(names))
+	for _, name := range names {
+		groups = append(groups, d.pods[name])
+	}
+
+	select {
+	case up <- groups:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
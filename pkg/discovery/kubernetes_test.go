@@ -0,0 +1,145 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const (
+	podList1 = `{"metadata": {"resourceVersion": "100"}, "items": [
+		{"metadata": {"name": "dynomite-0", "namespace": "dynomite"}, "status": {"phase": "Running", "podIP": "10.0.0.1"}},
+		{"metadata": {"name": "dynomite-1", "namespace": "dynomite"}, "status": {"phase": "Pending"}}
+	]}`
+	podAdded   = `{"type": "ADDED", "object": {"metadata": {"name": "dynomite-2", "namespace": "dynomite"}, "status": {"phase": "Running", "podIP": "10.0.0.3"}}}`
+	podDeleted = `{"type": "DELETED", "object": {"metadata": {"name": "dynomite-0", "namespace": "dynomite"}, "status": {"phase": "Running", "podIP": "10.0.0.1"}}}`
+	podError   = `{"type": "ERROR", "object": {"kind": "Status", "code": 410, "reason": "Expired"}}`
+)
+
+// newKubernetesTestServer serves list as the pod list, and watch as the body
+// of the watch that follows.
+func newKubernetesTestServer(t *testing.T, list, watch string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/dynomite/pods" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("labelSelector"); got != "app=dynomite" {
+			t.Errorf("labelSelector = %q, want app=dynomite", got)
+		}
+		if r.URL.Query().Get("watch") == "" {
+			w.Write([]byte(list))
+			return
+		}
+		if got := r.URL.Query().Get("resourceVersion"); got != "100" {
+			t.Errorf("resourceVersion = %q, want 100", got)
+		}
+		w.Write([]byte(watch))
+	}))
+}
+
+func newTestKubernetesDiscovery(t *testing.T, server string) *KubernetesDiscovery {
+	d := NewKubernetesDiscovery(&KubernetesSDConfig{
+		APIServer:     server,
+		Namespace:     "dynomite",
+		LabelSelector: "app=dynomite",
+		Port:          22222,
+	}, log.NewNopLogger())
+	if err := d.connect(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// targetsOf returns the targets of every update sent on up.
+func targetsOf(up chan []*Group) [][]string {
+	close(up)
+	var updates [][]string
+	for groups := range up {
+		targets := []string{}
+		for _, g := range groups {
+			targets = append(targets, g.Targets...)
+		}
+		updates = append(updates, targets)
+	}
+	return updates
+}
+
+func TestKubernetesDiscoveryListAndWatch(t *testing.T) {
+	srv := newKubernetesTestServer(t, podList1, podAdded+"\n"+podDeleted+"\n")
+	defer srv.Close()
+	d := newTestKubernetesDiscovery(t, srv.URL)
+
+	up := make(chan []*Group, 10)
+	if err := d.listAndWatch(context.Background(), up); err != nil {
+		t.Fatalf("listAndWatch() = %v, want nil when the watch ends", err)
+	}
+	want := [][]string{
+		{"10.0.0.1:22222"},
+		{"10.0.0.1:22222", "10.0.0.3:22222"},
+		{"10.0.0.3:22222"},
+	}
+	if got := targetsOf(up); !reflect.DeepEqual(got, want) {
+		t.Errorf("updates = %v, want %v", got, want)
+	}
+	wantLabels := map[string]string{"namespace": "dynomite", "pod": "dynomite-2"}
+	if got := d.pods["dynomite-2"].Labels; !reflect.DeepEqual(got, wantLabels) {
+		t.Errorf("labels = %v, want %v", got, wantLabels)
+	}
+}
+
+func TestKubernetesDiscoveryWatchErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		watch string
+	}{
+		{"error event", podAdded + "\n" + podError + "\n"},
+		{"truncated", podAdded + "\n" + `{"type": "ADDED", "object": {"meta`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newKubernetesTestServer(t, podList1, tt.watch)
+			defer srv.Close()
+			d := newTestKubernetesDiscovery(t, srv.URL)
+
+			up := make(chan []*Group, 10)
+			if err := d.listAndWatch(context.Background(), up); err == nil {
+				t.Fatal("listAndWatch() = nil, want an error")
+			}
+			if got := targetsOf(up); len(got) != 2 {
+				t.Errorf("updates = %v, want the list and the added pod", got)
+			}
+		})
+	}
+}
+
+func TestKubernetesDiscoveryListError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	d := newTestKubernetesDiscovery(t, srv.URL)
+
+	up := make(chan []*Group, 10)
+	if err := d.listAndWatch(context.Background(), up); err == nil {
+		t.Fatal("listAndWatch() = nil, want an error")
+	}
+	if got := targetsOf(up); len(got) != 0 {
+		t.Errorf("updates = %v, want none", got)
+	}
+}
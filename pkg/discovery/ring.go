@@ -0,0 +1,187 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/foxdalas/dynomite-exporter/pkg/exporter"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/config"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RingSDConfig configures the discovery of the nodes of a dynomite ring from
+// the cluster description of seed nodes.
+type RingSDConfig struct {
+	// Seeds are the admin addresses of nodes of the ring.
+	Seeds []string `yaml:"seeds"`
+	// Port is the admin port of the nodes, defaulting to the one of the
+	// seed. The ports in the cluster description are the peer ports.
+	Port int `yaml:"port,omitempty"`
+	// RefreshInterval is how often the ring is described.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+	// Timeout is the timeout of describing the ring.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// HTTPClientConfig configures TLS, authentication and the proxy for
+	// requesting the seeds and the stats endpoints of the nodes found.
+	HTTPClientConfig *config.HTTPClientConfig `yaml:"http_client_config,omitempty"`
+}
+
+const (
+	// DefaultRingSDRefreshInterval is the refresh interval of a
+	// RingSDConfig not setting one.
+	DefaultRingSDRefreshInterval = 30 * time.Second
+	// DefaultRingSDTimeout is the timeout of a RingSDConfig not setting
+	// one.
+	DefaultRingSDTimeout = 5 * time.Second
+)
+
+// Validate checks the configuration.
+func (c *RingSDConfig) Validate() error {
+	if len(c.Seeds) == 0 {
+		return fmt.Errorf("ring_sd_config has no seeds")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("ring_sd_config: invalid port %d", c.Port)
+	}
+	for _, seed := range c.Seeds {
+		u, err := url.Parse(exporter.NormalizeAddress(seed))
+		if err != nil {
+			return fmt.Errorf("ring_sd_config: invalid seed %q: %w", seed, err)
+		}
+		if c.Port == 0 && u.Port() == "" {
+			return fmt.Errorf("ring_sd_config: seed %q has no port and no port is configured", seed)
+		}
+	}
+	return validateHTTPClientConfig("ring_sd_config", c.HTTPClientConfig)
+}
+
+// RingDiscovery finds the nodes of a dynomite ring from the cluster
+// description of the first seed answering, every refresh interval.
+type RingDiscovery struct {
+	seeds    []string
+	port     int
+	interval time.Duration
+	timeout  time.Duration
+	client   *http.Client
+	logger   log.Logger
+
+	// groups holds the groups last found, which are kept while no seed
+	// answers.
+	groups []*Group
+}
+
+// NewRingDiscovery returns a discoverer for cfg.
+func NewRingDiscovery(cfg *RingSDConfig, logger log.Logger) *RingDiscovery {
+	interval := cfg.RefreshInterval
+	if interval == 0 {
+		interval = DefaultRingSDRefreshInterval
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultRingSDTimeout
+	}
+	client := exporter.NewHTTPClient()
+	if cfg.HTTPClientConfig != nil {
+		client = exporter.NewHTTPClientFromLoadedConfig(cfg.HTTPClientConfig, logger)
+	}
+	return &RingDiscovery{
+		seeds:    cfg.Seeds,
+		port:     cfg.Port,
+		interval: interval,
+		timeout:  timeout,
+		client:   client,
+		logger:   logger,
+		groups:   []*Group{},
+	}
+}
+
+// Run implements Discoverer.
+func (d *RingDiscovery) Run(ctx context.Context, up chan<- []*Group) {
+	poll(ctx, d.interval, up, d.refresh)
+}
+
+// refresh describes the ring and returns a group for each rack.
+func (d *RingDiscovery) refresh(ctx context.Context) []*Group {
+	for _, seed := range d.seeds {
+		groups, err := d.describe(ctx, seed)
+		if err != nil {
+			level.Warn(d.logger).Log("msg", "Error describing ring", "seed", seed, "err", err)
+			continue
+		}
+		d.groups = groups
+		return groups
+	}
+	level.Error(d.logger).Log("msg", "No seed described the ring", "seeds", len(d.seeds))
+	return d.groups
+}
+
+// describe returns the groups of the ring as seen by seed. The nodes found
+// through an https:// seed are requested over https as well.
+func (d *RingDiscovery) describe(ctx context.Context, seed string) ([]*Group, error) {
+	address := exporter.NormalizeAddress(seed)
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	port := d.port
+	if port == 0 {
+		if port, err = strconv.Atoi(u.Port()); err != nil {
+			return nil, fmt.Errorf("invalid port in seed: %w", err)
+		}
+	}
+	scheme := ""
+	if u.Scheme == "https" {
+		scheme = "https://"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	desc, err := exporter.GetClusterDescription(ctx, d.client, address)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []*Group{}
+	for _, dc := range desc.Dcs {
+		for _, rack := range dc.Racks {
+			g := &Group{
+				Source: dc.Name + "/" + rack.Name,
+				Labels: map[string]string{
+					"ring_dc":   dc.Name,
+					"ring_rack": rack.Name,
+				},
+			}
+			for _, node := range rack.Servers {
+				host := node.Host
+				if host == "" {
+					host = node.Name
+				}
+				if host == "" {
+					level.Warn(d.logger).Log("msg", "Skipping node without host or name", "seed", seed, "dc", dc.Name, "rack", rack.Name)
+					continue
+				}
+				g.Targets = append(g.Targets, scheme+net.JoinHostPort(host, strconv.Itoa(port)))
+			}
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+const clusterDescription = `{"dcs": [{"name": "us-east-1", "racks": [
+	{"name": "rack-1", "servers": [
+		{"name": "dynomite-1", "host": "10.0.0.1", "port": 8101, "token": 1383429731},
+		{"name": "dynomite-2", "host": "", "port": 8101, "token": "2147483647"},
+		{"name": "", "host": "", "port": 8101, "token": 0}
+	]},
+	{"name": "rack-2", "servers": [
+		{"name": "dynomite-3", "host": "10.0.0.3", "port": 8101, "token": 1383429731}
+	]}
+]}]}`
+
+func newClusterDescribeHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cluster_describe" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Write([]byte(clusterDescription))
+	})
+}
+
+func TestRingDiscoveryDescribe(t *testing.T) {
+	srv := httptest.NewServer(newClusterDescribeHandler(t))
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(newClusterDescribeHandler(t))
+	defer tlsSrv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	seedPort := u.Port()
+
+	tests := []struct {
+		name   string
+		seed   string
+		port   int
+		client *http.Client
+		want   [][]string
+	}{
+		{
+			name: "port of the seed",
+			seed: u.Host,
+			want: [][]string{
+				{"10.0.0.1:" + seedPort, "dynomite-2:" + seedPort},
+				{"10.0.0.3:" + seedPort},
+			},
+		},
+		{
+			name: "configured port",
+			seed: srv.URL,
+			port: 22222,
+			want: [][]string{
+				{"10.0.0.1:22222", "dynomite-2:22222"},
+				{"10.0.0.3:22222"},
+			},
+		},
+		{
+			name:   "https seed",
+			seed:   tlsSrv.URL,
+			port:   22223,
+			client: tlsSrv.Client(),
+			want: [][]string{
+				{"https://10.0.0.1:22223", "https://dynomite-2:22223"},
+				{"https://10.0.0.3:22223"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewRingDiscovery(&RingSDConfig{Seeds: []string{tt.seed}, Port: tt.port}, log.NewNopLogger())
+			if tt.client != nil {
+				d.client = tt.client
+			}
+			groups, err := d.describe(context.Background(), tt.seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]string
+			for _, g := range groups {
+				got = append(got, g.Targets)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targets = %v, want %v", got, tt.want)
+			}
+			wantLabels := map[string]string{"ring_dc": "us-east-1", "ring_rack": "rack-2"}
+			if !reflect.DeepEqual(groups[1].Labels, wantLabels) {
+				t.Errorf("labels = %v, want %v", groups[1].Labels, wantLabels)
+			}
+		})
+	}
+}
+
+func TestRingDiscoveryRefreshSeeds(t *testing.T) {
+	up := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(clusterDescription))
+	}))
+	defer srv.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	d := NewRingDiscovery(&RingSDConfig{Seeds: []string{down.URL, srv.URL}, Port: 22222}, log.NewNopLogger())
+	groups := d.refresh(context.Background())
+	if len(groups) != 2 {
+		t.Fatalf("refresh() = %v, want the ring described by the second seed", groups)
+	}
+
+	up = false
+	if got := d.refresh(context.Background()); !reflect.DeepEqual(got, groups) {
+		t.Errorf("refresh() with no seed answering = %v, want the groups last found", got)
+	}
+}
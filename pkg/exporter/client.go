@@ -0,0 +1,283 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/config"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// adminResponseSize limits how much of an admin endpoint response is read.
+const adminResponseSize = 1 << 20
+
+// NormalizeAddress returns the URL of the stats endpoint for address, which
+// may be given as a bare host:port, an http:// or https:// URL, or the path of
+// a unix socket, bare or as a unix:// URL. An empty address is returned
+// unchanged.
+func NormalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+	switch {
+	case address == "", strings.Contains(address, "://"):
+		return address
+	case strings.HasPrefix(address, "/"):
+		return "unix://" + address
+	}
+	return "http://" + address
+}
+
+// socketKey is the context key of the unix socket a request is dialed to.
+type socketKey struct{}
+
+// newRequest returns a GET request for path on the stats port serving at
+// address, or for the stats themselves if path is empty. A unix:// address
+// is requested over its socket: the socket path is passed to the dialer of
+// the transport in the request context, and also encoded as the host so
+// connections to different sockets are not pooled together.
+func newRequest(ctx context.Context, address, path string) (*http.Request, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "unix" {
+		if u.Path == "" {
+			return nil, fmt.Errorf("no socket path in %q", address)
+		}
+		ctx = context.WithValue(ctx, socketKey{}, u.Path)
+		u = &url.URL{Scheme: "http", Host: hex.EncodeToString([]byte(u.Path)), Path: "/"}
+	}
+	if path != "" {
+		u.Path = path
+		u.RawQuery = ""
+	}
+	return http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+}
+
+// NewHTTPClient returns a client for talking to dynomite stats endpoints.
+// Requests are bounded by their context only, so that targets with different
+// timeouts can share a client. The client is safe for concurrent use and
+// should be shared between scrapes.
+func NewHTTPClient() *http.Client {
+	return &http.Client{Transport: newTransport()}
+}
+
+// NewHTTPClientFromConfig returns a client like NewHTTPClient, using the TLS
+// settings, authentication and proxy of cfg. Passwords and bearer tokens
+// given as files are read on every request, so they can be rotated.
+func NewHTTPClientFromConfig(cfg *config.HTTPClientConfig) (*http.Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.NewTLSConfig(&cfg.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := newTransport()
+	transport.TLSClientConfig = tlsConfig
+	if cfg.ProxyURL.URL != nil {
+		transport.Proxy = socketlessProxy(http.ProxyURL(cfg.ProxyURL.URL))
+	}
+
+	var rt http.RoundTripper = transport
+	switch {
+	case cfg.BasicAuth != nil:
+		rt = config.NewBasicAuthRoundTripper(cfg.BasicAuth.Username, cfg.BasicAuth.Password, cfg.BasicAuth.PasswordFile, rt)
+	case cfg.BearerToken != "":
+		rt = config.NewBearerAuthRoundTripper(cfg.BearerToken, rt)
+	case cfg.BearerTokenFile != "":
+		rt = config.NewBearerAuthFileRoundTripper(cfg.BearerTokenFile, rt)
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy: socketlessProxy(http.ProxyFromEnvironment),
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if socket, ok := ctx.Value(socketKey{}).(string); ok {
+				return dialer.DialContext(ctx, "unix", socket)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: 2,
+	}
+}
+
+// socketlessProxy returns proxy for requests dialed over TCP, and no proxy
+// for those dialed to a unix socket, whose host is not a real one.
+func socketlessProxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if _, ok := req.Context().Value(socketKey{}).(string); ok {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
+
+// NewErrorHTTPClient returns a client failing every request with err, standing
+// in for a client whose configuration could not be loaded.
+func NewErrorHTTPClient(err error) *http.Client {
+	return &http.Client{Transport: errorTransport{err: err}}
+}
+
+// NewHTTPClientFromLoadedConfig returns a client like NewHTTPClientFromConfig
+// for a configuration already validated when it was loaded. A file it refers
+// to may have changed since: the error is then logged, and the client returned
+// fails every request with it.
+func NewHTTPClientFromLoadedConfig(cfg *config.HTTPClientConfig, logger log.Logger) *http.Client {
+	client, err := NewHTTPClientFromConfig(cfg)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating HTTP client", "err", err)
+		return NewErrorHTTPClient(err)
+	}
+	return client
+}
+
+// errorTransport fails every request with err.
+type errorTransport struct {
+	err error
+}
+
+func (t errorTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// Reasons a scrape can fail for, see ScrapeError.
+const (
+	// ReasonDial means no connection to the stats endpoint could be made.
+	ReasonDial = "dial"
+	// ReasonTimeout means the scrape did not complete in time.
+	ReasonTimeout = "timeout"
+	// ReasonHTTPStatus means the stats endpoint answered with a status other
+	// than 200 OK.
+	ReasonHTTPStatus = "http_status"
+	// ReasonDecode means the stats document could not be decoded.
+	ReasonDecode = "decode"
+	// ReasonCircuitOpen means the stats endpoint was not requested, as the
+	// circuit breaker of the target is open.
+	ReasonCircuitOpen = "circuit_open"
+)
+
+// ScrapeError is the error returned by GetMetrics, classifying why the scrape
+// failed.
+type ScrapeError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ScrapeError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+// scrapeError wraps err into a ScrapeError, classifying it as a timeout
+// rather than reason when it was caused by ctx expiring or a network timeout.
+func scrapeError(ctx context.Context, reason string, err error) error {
+	var netErr net.Error
+	if ctx.Err() == context.DeadlineExceeded || (errors.As(err, &netErr) && netErr.Timeout()) {
+		reason = ReasonTimeout
+	}
+	return &ScrapeError{Reason: reason, Err: err}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// GetMetrics fetches and decodes the stats document served at address, see
+// NormalizeAddress. The request is aborted as soon as ctx is done. Errors are
+// of type *ScrapeError.
+func GetMetrics(ctx context.Context, client *http.Client, address string) (DynomiteMetrics, error) {
+	metrics, _, err := getMetrics(ctx, client, address)
+	return metrics, err
+}
+
+// getMetrics is GetMetrics, additionally returning the size of the stats
+// document.
+func getMetrics(ctx context.Context, client *http.Client, address string) (DynomiteMetrics, int64, error) {
+	var metrics DynomiteMetrics
+
+	req, err := newRequest(ctx, NormalizeAddress(address), "")
+	if err != nil {
+		return metrics, 0, &ScrapeError{Reason: ReasonDial, Err: err}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return metrics, 0, scrapeError(ctx, ReasonDial, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return metrics, 0, &ScrapeError{Reason: ReasonHTTPStatus, Err: fmt.Errorf("unexpected status %s", res.Status)}
+	}
+
+	body := &countingReader{r: res.Body}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return metrics, body.n, scrapeError(ctx, ReasonDecode, err)
+	}
+	metrics, err = decodeStats(data)
+	if err != nil {
+		return metrics, body.n, scrapeError(ctx, ReasonDecode, err)
+	}
+	return metrics, body.n, nil
+}
+
+// getAdmin returns the body of the admin endpoint path on the stats port
+// serving address.
+func getAdmin(ctx context.Context, client *http.Client, address, path string) ([]byte, error) {
+	req, err := newRequest(ctx, NormalizeAddress(address), path)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", res.Status, path)
+	}
+	return ioutil.ReadAll(io.LimitReader(res.Body, adminResponseSize))
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"github.com/prometheus/common/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"dynomite-1:22222", "http://dynomite-1:22222"},
+		{" dynomite-1:22222 ", "http://dynomite-1:22222"},
+		{"[2001:db8::1]:22222", "http://[2001:db8::1]:22222"},
+		{"http://dynomite-1:22222", "http://dynomite-1:22222"},
+		{"https://dynomite-1:22223/", "https://dynomite-1:22223/"},
+		{"/var/run/dynomite/stats.sock", "unix:///var/run/dynomite/stats.sock"},
+		{"unix:///var/run/dynomite/stats.sock", "unix:///var/run/dynomite/stats.sock"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeAddress(tt.address); got != tt.want {
+			t.Errorf("NormalizeAddress(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+// newSocketServer returns a server serving the stats document on a unix
+// socket, and the path of the socket.
+func newSocketServer(t *testing.T) (*httptest.Server, string) {
+	socket := filepath.Join(t.TempDir(), "stats.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"service": "dynomite", "uptime": 42}`))
+	}))
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	return srv, socket
+}
+
+func TestGetMetricsUnixSocket(t *testing.T) {
+	srv, socket := newSocketServer(t)
+	defer srv.Close()
+
+	for _, address := range []string{socket, "unix://" + socket} {
+		stats, _, err := getMetrics(context.Background(), NewHTTPClient(), NormalizeAddress(address))
+		if err != nil {
+			t.Errorf("getMetrics(%q) = %v", address, err)
+			continue
+		}
+		if stats.Uptime != 42 {
+			t.Errorf("getMetrics(%q): uptime = %d, want 42", address, stats.Uptime)
+		}
+	}
+}
+
+func TestGetMetricsUnixSocketBypassesProxy(t *testing.T) {
+	srv, socket := newSocketServer(t)
+	defer srv.Close()
+
+	proxied := 0
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+		w.Write([]byte(`{"service": "dynomite", "uptime": 7}`))
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	client, err := NewHTTPClientFromConfig(&config.HTTPClientConfig{ProxyURL: config.URL{URL: proxyURL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, _, err := getMetrics(context.Background(), client, "unix://"+socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Uptime != 42 || proxied != 0 {
+		t.Errorf("unix socket request proxied: uptime = %d, %d proxied requests", stats.Uptime, proxied)
+	}
+
+	// Requests over TCP still go through the proxy.
+	if _, _, err := getMetrics(context.Background(), client, "http://dynomite-1.invalid:22222"); err != nil {
+		t.Fatal(err)
+	}
+	if proxied != 1 {
+		t.Errorf("%d proxied requests, want 1", proxied)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// statsRenames maps the keys under which dynomite versions older than before
+// report statistics to the keys of the stats types, for statistics that were
+// renamed. The renames of every entry whose version is newer than the one
+// reported apply, to documents not reporting the new key already.
+var statsRenames = []struct {
+	before string
+	keys   map[string]string
+}{
+	{
+		// Before 0.6, the client responses not reaching quorum were
+		// counted together. They are exported as the write responses, the
+		// read responses staying zero, so that they are not lost.
+		before: "0.6.0",
+		keys: map[string]string{
+			"client_non_quorum_responses": "client_non_quorum_w_responses",
+		},
+	},
+}
+
+// statsDecoder decodes stats documents leniently, so that a build of dynomite
+// reporting a statistic with an unexpected type fails only that statistic
+// rather than the whole scrape. Numbers are accepted as any JSON number or
+// numeric string and converted to the type of their field; a field that
+// cannot be converted is left zero and recorded in skipped.
+type statsDecoder struct {
+	renames map[string]string
+	skipped []string
+}
+
+// decodeStats decodes the stats document data.
+func decodeStats(data []byte) (DynomiteMetrics, error) {
+	var stats DynomiteMetrics
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return stats, err
+	}
+
+	d := &statsDecoder{}
+	var version string
+	if raw, ok := fields["version"]; ok {
+		d.decodeValue("version", raw, reflect.ValueOf(&version).Elem())
+		d.skipped = nil
+	}
+	d.renames = renamesFor(version)
+
+	known := d.decodeStruct("", fields, reflect.ValueOf(&stats).Elem())
+
+	// Any other object is a pool, named in the configuration of the node.
+	for name, raw := range fields {
+		if known[name] || !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			continue
+		}
+		var pool PoolMetrics
+		d.decodePool(name+".", raw, &pool)
+		if stats.Pools == nil {
+			stats.Pools = make(map[string]PoolMetrics)
+		}
+		stats.Pools[name] = pool
+	}
+
+	stats.SkippedFields = d.skipped
+	return stats, nil
+}
+
+// renamesFor returns the renames applying to the stats of version.
+func renamesFor(version string) map[string]string {
+	renames := make(map[string]string)
+	for _, r := range statsRenames {
+		if version != "" && compareVersions(version, r.before) >= 0 {
+			continue
+		}
+		for from, to := range r.keys {
+			renames[from] = to
+		}
+	}
+	return renames
+}
+
+// compareVersions compares dotted versions such as 0.6.22 or v0.7.0-rc1
+// numerically by component, ignoring a leading v and any suffix of a
+// component starting with a non-digit.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = leadingInt(as[i])
+		}
+		if i < len(bs) {
+			y = leadingInt(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func leadingInt(s string) int {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
+
+// decodeStruct decodes the JSON object fields into the struct v, by the json
+// tags of its fields, and returns the keys of the fields decoded. prefix is
+// prepended to the keys recorded as skipped.
+func (d *statsDecoder) decodeStruct(prefix string, fields map[string]json.RawMessage, v reflect.Value) map[string]bool {
+	known := make(map[string]bool)
+	for key, raw := range fields {
+		if to, ok := d.renames[key]; ok {
+			if _, exists := fields[to]; !exists {
+				fields[to] = raw
+			}
+			known[key] = true
+		}
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		raw, ok := fields[key]
+		if !ok {
+			continue
+		}
+		known[key] = true
+		d.decodeValue(prefix+key, raw, v.Field(i))
+	}
+	return known
+}
+
+// decodePool decodes the pool statistics raw into p, collecting the objects
+// nested into the pool under the name of each server or peer. Peer objects
+// are told apart by the dc and rack they carry.
+func (d *statsDecoder) decodePool(prefix string, raw json.RawMessage, p *PoolMetrics) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		d.skipped = append(d.skipped, strings.TrimSuffix(prefix, "."))
+		return
+	}
+	d.decodeStruct(prefix, fields, reflect.ValueOf(p).Elem())
+
+	for name, raw := range fields {
+		if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			continue
+		}
+
+		var entry map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			d.skipped = append(d.skipped, prefix+name)
+			continue
+		}
+		_, hasDc := entry["dc"]
+		_, hasRack := entry["rack"]
+		if hasDc || hasRack {
+			var peer PeerMetrics
+			d.decodeStruct(prefix+name+".", entry, reflect.ValueOf(&peer).Elem())
+			if p.Peers == nil {
+				p.Peers = make(map[string]PeerMetrics)
+			}
+			p.Peers[name] = peer
+			continue
+		}
+
+		var server ServerMetrics
+		d.decodeStruct(prefix+name+".", entry, reflect.ValueOf(&server).Elem())
+		if p.Servers == nil {
+			p.Servers = make(map[string]ServerMetrics)
+		}
+		p.Servers[name] = server
+	}
+}
+
+// decodeValue decodes the JSON scalar raw into the string or numeric v,
+// recording key as skipped if it cannot be converted. null leaves v zero.
+func (d *statsDecoder) decodeValue(key string, raw json.RawMessage, v reflect.Value) {
+	raw = bytes.TrimSpace(raw)
+	if bytes.Equal(raw, []byte("null")) {
+		return
+	}
+
+	var s string
+	if bytes.HasPrefix(raw, []byte(`"`)) {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			d.skipped = append(d.skipped, key)
+			return
+		}
+	} else {
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			d.skipped = append(d.skipped, key)
+			return
+		}
+		s = n.String()
+	}
+
+	if err := setValue(v, strings.TrimSpace(s)); err != nil {
+		d.skipped = append(d.skipped, key)
+	}
+}
+
+// setValue sets v to the string or number s, converted to the type of v.
+// Fractional numbers are truncated for integer fields.
+func setValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && !v.OverflowInt(n) {
+			v.SetInt(n)
+			return nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 || v.OverflowInt(int64(f)) {
+			return fmt.Errorf("%s overflows %s", s, v.Type())
+		}
+		v.SetInt(int64(f))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", v.Type())
+}
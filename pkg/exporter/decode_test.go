@@ -0,0 +1,209 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDecodeStatsCoercion(t *testing.T) {
+	stats, err := decodeStats([]byte(`{
+		"service": "dynomite",
+		"version": 0.6,
+		"uptime": "1234",
+		"timestamp": 1.6e9,
+		"latency_max": 12.7,
+		"latency_mean": " 42 ",
+		"dyn_memory": -3,
+		"rack": 5,
+		"dc": null
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DynomiteMetrics{
+		Service:     "dynomite",
+		Version:     "0.6",
+		Uptime:      1234,
+		Timestamp:   1600000000,
+		LatencyMax:  12,
+		LatencyMean: 42,
+		DynMemory:   -3,
+		Rack:        "5",
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("decodeStats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestDecodeStatsSkippedFields(t *testing.T) {
+	stats, err := decodeStats([]byte(`{
+		"uptime": 1234,
+		"alloc_msgs": true,
+		"free_msgs": {"a": 1},
+		"free_mbufs": [1],
+		"latency_max": "slow",
+		"dyn_memory": 1e30,
+		"dyn_o_mite": {
+			"client_eof": "x",
+			"stats_count": "42",
+			"127.0.0.1:6379": {"read_requests": "9", "in_queue": [1]}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Uptime != 1234 {
+		t.Errorf("Uptime = %d, want 1234", stats.Uptime)
+	}
+	pool := stats.Pools["dyn_o_mite"]
+	if pool.StatsCount != 42 || pool.Servers["127.0.0.1:6379"].ReadRequests != 9 {
+		t.Errorf("pool = %+v, want the decodable statistics", pool)
+	}
+
+	skipped := append([]string(nil), stats.SkippedFields...)
+	sort.Strings(skipped)
+	want := []string{
+		"alloc_msgs",
+		"dyn_memory",
+		"dyn_o_mite.127.0.0.1:6379.in_queue",
+		"dyn_o_mite.client_eof",
+		"free_mbufs",
+		"free_msgs",
+		"latency_max",
+	}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("SkippedFields = %v, want %v", skipped, want)
+	}
+}
+
+func TestDecodeStatsInvalid(t *testing.T) {
+	for _, data := range []string{`[1]`, `{"uptime": `, ``} {
+		if _, err := decodeStats([]byte(data)); err == nil {
+			t.Errorf("decodeStats(%q) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestDecodeStatsPools(t *testing.T) {
+	stats, err := decodeStats([]byte(`{
+		"service": "dynomite",
+		"rack": "rack-1",
+		"dyn_o_mite": {
+			"client_connections": 3,
+			"127.0.0.1:6379": {"server_connections": 1, "read_requests": 10},
+			"dynomite-2": {"dc": "us-east-1", "rack": "rack-2", "peer_connections": 2}
+		},
+		"cache": {
+			"client_connections": 5
+		},
+		"empty": {}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]PoolMetrics{
+		"dyn_o_mite": {
+			ClientConnections: 3,
+			Servers: map[string]ServerMetrics{
+				"127.0.0.1:6379": {ServerConnections: 1, ReadRequests: 10},
+			},
+			Peers: map[string]PeerMetrics{
+				"dynomite-2": {Dc: "us-east-1", Rack: "rack-2", PeerConnections: 2},
+			},
+		},
+		"cache": {ClientConnections: 5},
+		"empty": {},
+	}
+	if !reflect.DeepEqual(stats.Pools, want) {
+		t.Errorf("Pools = %+v, want %+v", stats.Pools, want)
+	}
+	if stats.Rack != "rack-1" {
+		t.Errorf("Rack = %q, want rack-1", stats.Rack)
+	}
+}
+
+func TestDecodeStatsNoPools(t *testing.T) {
+	stats, err := decodeStats([]byte(`{"service": "dynomite", "uptime": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pools != nil {
+		t.Errorf("Pools = %+v, want none", stats.Pools)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0.6.0", "0.6.0", 0},
+		{"0.5.9", "0.6.0", -1},
+		{"0.6.22", "0.6.3", 1},
+		{"v0.7.0-rc1", "0.7.0", 0},
+		{"0.6", "0.6.0", 0},
+		{"1.0", "0.6.0", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeStatsVersionRenames(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantWrites int
+	}{
+		{
+			name:       "renamed before 0.6",
+			data:       `{"version": "0.5.9", "dyn_o_mite": {"client_non_quorum_responses": 7}}`,
+			wantWrites: 7,
+		},
+		{
+			name:       "new key kept",
+			data:       `{"version": "0.5.9", "dyn_o_mite": {"client_non_quorum_responses": 7, "client_non_quorum_w_responses": 3}}`,
+			wantWrites: 3,
+		},
+		{
+			name:       "not renamed from 0.6",
+			data:       `{"version": "0.6.22", "dyn_o_mite": {"client_non_quorum_responses": 7}}`,
+			wantWrites: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := decodeStats([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool := stats.Pools["dyn_o_mite"]
+			if pool.ClientNonQuorumWResponses != tt.wantWrites {
+				t.Errorf("ClientNonQuorumWResponses = %d, want %d", pool.ClientNonQuorumWResponses, tt.wantWrites)
+			}
+			if pool.ClientNonQuorumRResponses != 0 {
+				t.Errorf("ClientNonQuorumRResponses = %d, want 0", pool.ClientNonQuorumRResponses)
+			}
+			if len(stats.SkippedFields) != 0 {
+				t.Errorf("SkippedFields = %v, want none", stats.SkippedFields)
+			}
+		})
+	}
+}
@@ -14,11 +14,16 @@
 package exporter
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,225 +31,306 @@ const (
 	Namespace = "dynomite"
 )
 
-// Exporter collects metrics from a dynomite server.
-type Exporter struct {
-	address string
-	timeout time.Duration
-	logger  log.Logger
-
-	up     *prometheus.Desc
-	uptime *prometheus.Desc
-
-	latency *prometheus.Desc
+// scopeLabels are the variable labels every metric of a scope carries, ahead
+// of the fixed labels of its definition.
+var scopeLabels = map[scope][]string{
+	scopeNode:   {"rack"},
+	scopePool:   {"rack", "pool"},
+	scopeServer: {"rack", "pool", "server"},
+	scopePeer:   {"rack", "pool", "peer", "peer_dc", "peer_rack"},
+}
 
-	payload_size *prometheus.Desc
+// scopeTypes are the types metric paths of a scope are resolved against.
+var scopeTypes = map[scope]reflect.Type{
+	scopeNode:   reflect.TypeOf(DynomiteMetrics{}),
+	scopePool:   reflect.TypeOf(PoolMetrics{}),
+	scopeServer: reflect.TypeOf(ServerMetrics{}),
+	scopePeer:   reflect.TypeOf(PeerMetrics{}),
+}
 
-	cross_region_rtt *prometheus.Desc
+// Options configures an Exporter.
+type Options struct {
+	// Client performs the requests to the stats endpoint, see NewHTTPClient.
+	Client *http.Client
+	// Timeout bounds every scrape.
+	Timeout time.Duration
+	// LegacyMetrics additionally exports the metrics that were renamed when
+	// converting them to base units under their old names and units.
+	LegacyMetrics bool
+	// State queries the node state from the admin endpoint on every scrape.
+	State bool
+	// Topology queries the ring as seen by the node from the admin endpoint
+	// on every scrape.
+	Topology bool
+	// ScrapeMetrics, if not nil, records the outcome of every scrape.
+	ScrapeMetrics *ScrapeMetrics
+	// Labels are attached to every metric.
+	Labels prometheus.Labels
+	// PollInterval, if not zero, makes the exporter poll the node at this
+	// interval once started, and serve the results of the last poll when
+	// collected, see Exporter.Start.
+	PollInterval time.Duration
+	// Concurrency is the number of targets a TargetCollector scrapes at
+	// once, at least 1.
+	Concurrency int
+	// Retries is the number of times a failed request to the stats endpoint
+	// is retried within the timeout, waiting RetryBackoff doubled on every
+	// retry, with jitter.
+	Retries      int
+	RetryBackoff time.Duration
+	// BreakerThreshold, if not zero, is the number of consecutive failed
+	// scrapes after which the stats endpoint is no longer requested for
+	// BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// breaker, if not nil, is the circuit breaker to use instead of a new
+	// one, so that it outlives the exporter.
+	breaker *breaker
+}
 
-	cross_zone_latency *prometheus.Desc
-	server_latency     *prometheus.Desc
-	server_queue_wait  *prometheus.Desc
+// Exporter collects metrics from a dynomite server.
+type Exporter struct {
+	target        Target
+	client        *http.Client
+	timeout       time.Duration
+	scrapeMetrics *ScrapeMetrics
+	logger        log.Logger
 
-	cross_region_queue_wait *prometheus.Desc
+	up      *prometheus.Desc
+	descs   []*prometheus.Desc
+	metrics []metric
 
-	client_out_queue *prometheus.Desc
+	state *prometheus.Desc
 
-	server_in_queue  *prometheus.Desc
-	server_out_queue *prometheus.Desc
+	topologyNode      *prometheus.Desc
+	topologyRackNodes *prometheus.Desc
+	topologyDcNodes   *prometheus.Desc
 
-	dnode_client_out_queue *prometheus.Desc
+	retries      int
+	retryBackoff time.Duration
+	breaker      *breaker
+	breakerState *prometheus.Desc
 
-	peer_in_queue  *prometheus.Desc
-	peer_out_queue *prometheus.Desc
+	pollInterval time.Duration
+	snapshotAge  *prometheus.Desc
+	stop         context.CancelFunc
 
-	remote_peer_out_queue *prometheus.Desc
-	remote_peer_in_queue  *prometheus.Desc
+	mtx      sync.Mutex
+	snapshot *snapshot
+}
 
-	alloc_msgs  *prometheus.Desc
-	free_msgs   *prometheus.Desc
-	alloc_mbufs *prometheus.Desc
-	free_mbufs  *prometheus.Desc
-	dyn_memory  *prometheus.Desc
+// metric is a metricDef bound to its descriptors and to the location of its
+// value in the stats structs.
+type metric struct {
+	*metricDef
+	desc *prometheus.Desc
+	// remoteDesc is used for peers outside the local dc.
+	remoteDesc  *prometheus.Desc
+	index       []int
+	labelValues []string
 }
 
-// New returns an initialized exporter.
-func New(server string, timeout time.Duration, logger log.Logger) *Exporter {
-	return &Exporter{
-		address: server,
-		timeout: timeout,
-		logger:  logger,
+// New returns an initialized exporter for the stats endpoint at server, see
+// NormalizeAddress.
+func New(server string, opts Options, logger log.Logger) *Exporter {
+	e := &Exporter{
+		target:        Target{Name: server, Address: NormalizeAddress(server)},
+		client:        opts.Client,
+		timeout:       opts.Timeout,
+		scrapeMetrics: opts.ScrapeMetrics,
+		logger:        logger,
+		retries:       opts.Retries,
+		retryBackoff:  opts.RetryBackoff,
+		breaker:       opts.breaker,
 		up: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, "", "up"),
-			"Could the qynomite server be reached.",
-			nil,
-			nil,
-		),
-		uptime: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "uptime_seconds"),
-			"Number of seconds since the server started.",
-			[]string{"rack"},
-			nil,
-		),
-		latency: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "latency"),
-			"Server latency.",
-			[]string{"rack","type"},
-			nil,
-		),
-		payload_size: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "payload_size"),
-			"Payload size.",
-			[]string{"rack","type"},
-			nil,
-		),
-		cross_region_rtt: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "cross_region_rtt"),
-			"Cross region RTT.",
-			[]string{"rack","type"},
-			nil,
-		),
-		cross_zone_latency: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "cross_zone_latency"),
-			"Cross region latency.",
-			[]string{"rack","type"},
-			nil,
-		),
-		server_latency: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "server_latency"),
-			"Server latency.",
-			[]string{"rack","type"},
-			nil,
-		),
-		server_queue_wait: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "server_queue_wait"),
-			"Server queue wait.",
-			[]string{"rack","type"},
-			nil,
-		),
-		cross_region_queue_wait: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "cross_region_queue_wait"),
-			"Cross region queue wait.",
-			[]string{"rack","type"},
-			nil,
-		),
-		client_out_queue: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "client_out_queue"),
-			"Client out queue.",
-			[]string{"rack","type"},
+			"Could the dynomite server be reached.",
 			nil,
+			opts.Labels,
 		),
-		server_in_queue: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "server_in_queue"),
-			"Server in queue.",
-			[]string{"rack","type"},
-			nil,
-		),
-		server_out_queue: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "server_out_queue"),
-			"Server out queue.",
-			[]string{"rack","type"},
-			nil,
-		),
-		dnode_client_out_queue: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "dnode_client_out_queue"),
-			"Dnode client out queue.",
-			[]string{"rack","type"},
-			nil,
-		),
-		peer_in_queue: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "peer_in_queue"),
-			"Peer in queue.",
-			[]string{"rack","type"},
-			nil,
-		),
-		peer_out_queue: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "peer_out_queue"),
-			"Peer out queue.",
-			[]string{"rack","type"},
-			nil,
-		),
-		remote_peer_in_queue: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "remote_peer_in_queue"),
-			"Remote peer in queue.",
-			[]string{"rack","type"},
-			nil,
-		),
-		remote_peer_out_queue: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "remote_peer_out_queue"),
-			"Remote peer out queue.",
-			[]string{"rack","type"},
-			nil,
-		),
-		alloc_msgs: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "alloc_msgs"),
-			"The number of currently allocated messages.",
-			[]string{"rack"},
-			nil,
-		),
-		free_msgs: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "free_msgs"),
-			"The number of currently free messages.",
-			[]string{"rack"},
-			nil,
-		),
-		alloc_mbufs: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "alloc_mbufs"),
-			"The number of allocated mbufs.",
-			[]string{"rack"},
-			nil,
-		),
-		free_mbufs: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "free_mbufs"),
-			"The number of free mbufs.",
-			[]string{"rack"},
-			nil,
-		),
-		dyn_memory: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "dyn_memory"),
-			"Dynomite memory usage.",
-			[]string{"rack"},
+	}
+	if e.breaker == nil {
+		e.breaker = newBreaker(opts.BreakerThreshold, opts.BreakerCooldown)
+	}
+	if e.breaker != nil {
+		e.breakerState = prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "exporter", "circuit_breaker_state"),
+			"State of the circuit breaker of the dynomite server, 1 for the current state and 0 for all others.",
+			[]string{"state"},
+			opts.Labels,
+		)
+	}
+	if opts.PollInterval != 0 {
+		e.pollInterval = opts.PollInterval
+		e.snapshotAge = prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "exporter", "snapshot_age_seconds"),
+			"Time since the last background poll of the dynomite server whose results are served.",
 			nil,
-		),
+			opts.Labels,
+		)
+	}
+	if opts.State {
+		e.state = prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "node", "state"),
+			"State of the dynomite node, 1 for the current state and 0 for all others.",
+			[]string{"state"},
+			opts.Labels,
+		)
+	}
+	if opts.Topology {
+		e.topologyNode = prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "topology", "node_info"),
+			"A node of the ring as seen by the dynomite node.",
+			[]string{"dc", "rack", "host", "token"},
+			opts.Labels,
+		)
+		e.topologyRackNodes = prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "topology", "rack_nodes"),
+			"Number of nodes in a rack of the ring as seen by the dynomite node.",
+			[]string{"dc", "rack"},
+			opts.Labels,
+		)
+		e.topologyDcNodes = prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "topology", "dc_nodes"),
+			"Number of nodes in a dc of the ring as seen by the dynomite node.",
+			[]string{"dc"},
+			opts.Labels,
+		)
+	}
+
+	descs := make(map[string]*prometheus.Desc)
+	newDesc := func(subsystem, name, help string, labels []string) *prometheus.Desc {
+		fqName := prometheus.BuildFQName(Namespace, subsystem, name)
+		if d, ok := descs[fqName]; ok {
+			return d
+		}
+		d := prometheus.NewDesc(fqName, help, labels, opts.Labels)
+		descs[fqName] = d
+		e.descs = append(e.descs, d)
+		return d
+	}
+
+	defs := metricDefs
+	if opts.LegacyMetrics {
+		defs = append(defs[:len(defs):len(defs)], legacyMetricDefs...)
+	}
+	for i := range defs {
+		def := &defs[i]
+		index, err := fieldIndex(scopeTypes[def.scope], def.path)
+		if err != nil {
+			panic(err)
+		}
+		m := metric{metricDef: def, index: index}
+
+		labels := append([]string{}, scopeLabels[def.scope]...)
+		for name := range def.labels {
+			labels = append(labels, name)
+		}
+		sort.Strings(labels[len(scopeLabels[def.scope]):])
+		for _, name := range labels[len(scopeLabels[def.scope]):] {
+			m.labelValues = append(m.labelValues, def.labels[name])
+		}
+
+		if def.scope == scopePeer {
+			m.desc = newDesc(def.subsystem, def.name, def.help+" Local dc peers only.", labels)
+			m.remoteDesc = newDesc(def.subsystem, "remote_"+def.name, def.help+" Remote dc peers only.", labels)
+		} else {
+			m.desc = newDesc(def.subsystem, def.name, def.help, labels)
+		}
+		e.metrics = append(e.metrics, m)
 	}
+
+	return e
 }
 
 // Describe describes all the metrics exported by the dynomite exporter. It
 // implements prometheus.Collector.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.up
-	ch <- e.uptime
-	ch <- e.latency
-	ch <- e.payload_size
-	ch <- e.cross_region_rtt
-	ch <- e.cross_zone_latency
-	ch <- e.server_latency
-	ch <- e.server_queue_wait
-	ch <- e.cross_region_queue_wait
-	ch <- e.client_out_queue
-	ch <- e.server_in_queue
-	ch <- e.server_out_queue
-	ch <- e.dnode_client_out_queue
-	ch <- e.peer_in_queue
-	ch <- e.peer_out_queue
-	ch <- e.remote_peer_in_queue
-	ch <- e.remote_peer_out_queue
-	ch <- e.alloc_msgs
-	ch <- e.free_msgs
-	ch <- e.alloc_mbufs
-	ch <- e.free_mbufs
-	ch <- e.dyn_memory
+	for _, d := range e.descs {
+		ch <- d
+	}
+	if e.state != nil {
+		ch <- e.state
+	}
+	if e.topologyNode != nil {
+		ch <- e.topologyNode
+		ch <- e.topologyRackNodes
+		ch <- e.topologyDcNodes
+	}
+	if e.breakerState != nil {
+		ch <- e.breakerState
+	}
+	if e.snapshotAge != nil {
+		ch <- e.snapshotAge
+	}
 }
 
 // Collect fetches the statistics from the configured dynomite server, and
-// delivers them as Prometheus metrics. It implements prometheus.Collector.
+// delivers them as Prometheus metrics. In polling mode, the results of the
+// last poll are delivered instead. It implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	stats, err := GetMetrics(e.address)
+	e.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is Collect, scraping the dynomite server within the deadline
+// of ctx, if it is shorter than the timeout.
+func (e *Exporter) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	if e.pollInterval != 0 {
+		e.collectSnapshot(ch)
+		return
+	}
+	e.scrape(ctx, ch)
+}
+
+// ContextCollector is a prometheus.Collector that can collect within the
+// context of a scrape of the exporter, such as an Exporter or a
+// TargetCollector.
+type ContextCollector interface {
+	prometheus.Collector
+	CollectContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// WithContext returns a collector collecting c within ctx, for registering
+// in a registry created per scrape.
+func WithContext(ctx context.Context, c ContextCollector) prometheus.Collector {
+	return contextCollector{ContextCollector: c, ctx: ctx}
+}
+
+type contextCollector struct {
+	ContextCollector
+	ctx context.Context
+}
+
+func (c contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(c.ctx, ch)
+}
+
+// scrape fetches the statistics from the dynomite server and sends them on ch.
+func (e *Exporter) scrape(ctx context.Context, ch chan<- prometheus.Metric) {
+	start := time.Now()
+	stats, size, err := e.fetchMetrics(ctx)
+	if err != errScrapeEnded {
+		e.scrapeMetrics.observe(e.target.Name, start, size, err)
+	}
+	if e.breaker != nil {
+		e.collectBreaker(ch)
+	}
 	if err != nil {
 		ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, 0)
-		level.Error(e.logger).Log("msg", "Failed to connect to dynomite", "err", err)
+		var scrapeErr *ScrapeError
+		if err == errScrapeEnded || errors.As(err, &scrapeErr) && scrapeErr.Reason == ReasonCircuitOpen {
+			level.Debug(e.logger).Log("msg", "Skipping scrape of dynomite", "err", err)
+		} else {
+			level.Error(e.logger).Log("msg", "Failed to scrape dynomite", "err", err)
+		}
 		return
 	}
-	//TIMEOUT
+	if len(stats.SkippedFields) > 0 {
+		e.scrapeMetrics.skip(e.target.Name, len(stats.SkippedFields))
+		level.Debug(e.logger).Log("msg", "Skipped undecodable stats", "fields", strings.Join(stats.SkippedFields, ","))
+	}
 
 	up := float64(1)
 
@@ -253,75 +339,103 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	}
 
 	ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, up)
-}
-
-func (e *Exporter) parseStats(ch chan<- prometheus.Metric, stats DynomiteMetrics) error {
-	var parseError error
-
-	ch <- prometheus.MustNewConstMetric(e.uptime, prometheus.CounterValue, float64(stats.Uptime), stats.Rack)
-
-	ch <- prometheus.MustNewConstMetric(e.latency, prometheus.CounterValue, float64(stats.LatencyMax), stats.Rack, "max")
-	ch <- prometheus.MustNewConstMetric(e.latency, prometheus.CounterValue, float64(stats.Latency999Th), stats.Rack, "999")
-	ch <- prometheus.MustNewConstMetric(e.latency, prometheus.CounterValue, float64(stats.Latency99Th), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.latency, prometheus.CounterValue, float64(stats.Latency95Th), stats.Rack, "95")
-	ch <- prometheus.MustNewConstMetric(e.latency, prometheus.CounterValue, float64(stats.LatencyMean), stats.Rack, "50")
-
-	ch <- prometheus.MustNewConstMetric(e.payload_size, prometheus.GaugeValue, float64(stats.PayloadSizeMax), stats.Rack, "max")
-	ch <- prometheus.MustNewConstMetric(e.payload_size, prometheus.GaugeValue, float64(stats.PayloadSize999Th), stats.Rack, "999")
-	ch <- prometheus.MustNewConstMetric(e.payload_size, prometheus.GaugeValue, float64(stats.PayloadSize99Th), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.payload_size, prometheus.GaugeValue, float64(stats.PayloadSize95Th), stats.Rack, "95")
-	ch <- prometheus.MustNewConstMetric(e.payload_size, prometheus.GaugeValue, float64(stats.PayloadSizeMean), stats.Rack, "50")
-
-	ch <- prometheus.MustNewConstMetric(e.cross_region_rtt, prometheus.GaugeValue, float64(stats.Nine9CrossRegionRtt), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.cross_region_rtt, prometheus.GaugeValue, float64(stats.AverageCrossRegionRtt), stats.Rack, "50")
-
-	ch <- prometheus.MustNewConstMetric(e.cross_zone_latency, prometheus.GaugeValue, float64(stats.Nine9CrossZoneLatency), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.cross_zone_latency, prometheus.GaugeValue, float64(stats.AverageCrossZoneLatency), stats.Rack, "50")
-
-	ch <- prometheus.MustNewConstMetric(e.server_latency, prometheus.GaugeValue, float64(stats.Nine9ServerLatency), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.server_latency, prometheus.GaugeValue, float64(stats.AverageServerLatency), stats.Rack, "50")
 
-	ch <- prometheus.MustNewConstMetric(e.server_queue_wait, prometheus.GaugeValue, float64(stats.Nine9ServerQueueWait), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.server_queue_wait, prometheus.GaugeValue, float64(stats.AverageServerQueueWait), stats.Rack, "50")
-
-	ch <- prometheus.MustNewConstMetric(e.cross_region_queue_wait, prometheus.GaugeValue, float64(stats.Nine9CrossRegionQueueWait), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.cross_region_queue_wait, prometheus.GaugeValue, float64(stats.AverageCrossRegionQueueWait), stats.Rack, "50")
-
-	ch <- prometheus.MustNewConstMetric(e.client_out_queue, prometheus.GaugeValue, float64(stats.ClientOutQueue99), stats.Rack, "99")
-
-	ch <- prometheus.MustNewConstMetric(e.server_in_queue, prometheus.GaugeValue, float64(stats.ServerInQueue99), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.server_out_queue, prometheus.GaugeValue, float64(stats.ServerOutQueue99), stats.Rack, "99")
-
-	ch <- prometheus.MustNewConstMetric(e.dnode_client_out_queue, prometheus.GaugeValue, float64(stats.DnodeClientOutQueue99), stats.Rack, "99")
-
-	ch <- prometheus.MustNewConstMetric(e.peer_in_queue, prometheus.GaugeValue, float64(stats.PeerInQueue99), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.peer_out_queue, prometheus.GaugeValue, float64(stats.PeerOutQueue99), stats.Rack, "99")
-
-	ch <- prometheus.MustNewConstMetric(e.remote_peer_in_queue, prometheus.GaugeValue, float64(stats.RemotePeerInQueue99), stats.Rack, "99")
-	ch <- prometheus.MustNewConstMetric(e.remote_peer_out_queue, prometheus.GaugeValue, float64(stats.RemotePeerOutQueue99), stats.Rack, "99")
+	ctx, cancel := context.WithDeadline(ctx, start.Add(e.timeout))
+	defer cancel()
+	if e.state != nil {
+		e.collectState(ctx, ch)
+	}
+	if e.topologyNode != nil {
+		e.collectTopology(ctx, ch)
+	}
+}
 
-	ch <- prometheus.MustNewConstMetric(e.alloc_msgs, prometheus.GaugeValue, float64(stats.AllocMsgs), stats.Rack)
-	ch <- prometheus.MustNewConstMetric(e.free_msgs, prometheus.GaugeValue, float64(stats.FreeMsgs), stats.Rack)
+// collectState exports the state of the node as an enum, with the current
+// state set to 1 and every other known state to 0.
+func (e *Exporter) collectState(ctx context.Context, ch chan<- prometheus.Metric) {
+	state, err := GetState(ctx, e.client, e.target.Address)
+	if err != nil {
+		level.Error(e.logger).Log("msg", "Failed to get dynomite node state", "err", err)
+		return
+	}
 
-	ch <- prometheus.MustNewConstMetric(e.alloc_mbufs, prometheus.GaugeValue, float64(stats.AllocMbufs), stats.Rack)
-	ch <- prometheus.MustNewConstMetric(e.free_mbufs, prometheus.GaugeValue, float64(stats.FreeMbufs), stats.Rack)
+	known := false
+	for _, s := range nodeStates {
+		v := float64(0)
+		if s == state {
+			v = 1
+			known = true
+		}
+		ch <- prometheus.MustNewConstMetric(e.state, prometheus.GaugeValue, v, s)
+	}
+	if !known {
+		ch <- prometheus.MustNewConstMetric(e.state, prometheus.GaugeValue, 1, state)
+	}
+}
 
-	ch <- prometheus.MustNewConstMetric(e.dyn_memory, prometheus.GaugeValue, float64(stats.DynMemory), stats.Rack)
+func (e *Exporter) parseStats(ch chan<- prometheus.Metric, stats DynomiteMetrics) error {
+	var parseError error
 
+	node := reflect.ValueOf(stats)
+	for _, m := range e.metrics {
+		switch m.scope {
+		case scopeNode:
+			e.emit(ch, m, m.desc, node, stats.Rack)
+		case scopePool:
+			for pool, p := range stats.Pools {
+				e.emit(ch, m, m.desc, reflect.ValueOf(p), stats.Rack, pool)
+			}
+		case scopeServer:
+			for pool, p := range stats.Pools {
+				for name, server := range p.Servers {
+					e.emit(ch, m, m.desc, reflect.ValueOf(server), stats.Rack, pool, name)
+				}
+			}
+		case scopePeer:
+			for pool, p := range stats.Pools {
+				for name, peer := range p.Peers {
+					desc := m.desc
+					if peer.Dc != stats.Dc {
+						desc = m.remoteDesc
+					}
+					e.emit(ch, m, desc, reflect.ValueOf(peer), stats.Rack, pool, name, peer.Dc, peer.Rack)
+				}
+			}
+		}
+	}
 
 	return parseError
 }
 
-func GetMetrics(url string) (DynomiteMetrics, error) {
-	var metrics DynomiteMetrics
+// emit sends the value of m found in v, labelled with the scope label values
+// followed by the fixed label values of m.
+func (e *Exporter) emit(ch chan<- prometheus.Metric, m metric, desc *prometheus.Desc, v reflect.Value, labelValues ...string) {
+	value := m.value(number(v.FieldByIndex(m.index)))
+	ch <- prometheus.MustNewConstMetric(desc, m.valueType, value, append(labelValues, m.labelValues...)...)
+}
 
-	res, err := http.Get(url)
+// collectTopology exports the nodes of the ring as seen by the node, and the
+// number of nodes per rack and dc.
+func (e *Exporter) collectTopology(ctx context.Context, ch chan<- prometheus.Metric) {
+	desc, err := GetClusterDescription(ctx, e.client, e.target.Address)
 	if err != nil {
-		return metrics, err
+		level.Error(e.logger).Log("msg", "Failed to describe dynomite cluster", "err", err)
+		return
 	}
-	defer res.Body.Close()
 
-	decoder := json.NewDecoder(res.Body)
-	err = decoder.Decode(&metrics)
-	return metrics, err
+	for _, dc := range desc.Dcs {
+		dcNodes := 0
+		for _, rack := range dc.Racks {
+			for _, node := range rack.Servers {
+				host := node.Host
+				if host == "" {
+					host = node.Name
+				}
+				ch <- prometheus.MustNewConstMetric(e.topologyNode, prometheus.GaugeValue, 1, dc.Name, rack.Name, host, string(node.Token))
+			}
+			ch <- prometheus.MustNewConstMetric(e.topologyRackNodes, prometheus.GaugeValue, float64(len(rack.Servers)), dc.Name, rack.Name)
+			dcNodes += len(rack.Servers)
+		}
+		ch <- prometheus.MustNewConstMetric(e.topologyDcNodes, prometheus.GaugeValue, float64(dcNodes), dc.Name)
+	}
 }
@@ -0,0 +1,131 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStatsServer returns a server serving the stats document in file.
+func newStatsServer(file string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, file)
+	}))
+}
+
+func TestExporterCollect(t *testing.T) {
+	srv := newStatsServer("testdata/stats.json")
+	defer srv.Close()
+
+	e := New(srv.URL, Options{
+		Client:        NewHTTPClient(),
+		Timeout:       time.Second,
+		LegacyMetrics: true,
+	}, log.NewNopLogger())
+
+	expected := `
+# HELP dynomite_up Could the dynomite server be reached.
+# TYPE dynomite_up gauge
+dynomite_up 1
+# HELP dynomite_latency_seconds Server latency in seconds, by quantile.
+# TYPE dynomite_latency_seconds gauge
+dynomite_latency_seconds{quantile="0.95",rack="rack-1"} 0.0003
+dynomite_latency_seconds{quantile="0.99",rack="rack-1"} 0.0006
+dynomite_latency_seconds{quantile="0.999",rack="rack-1"} 0.0009
+# HELP dynomite_latency_mean_seconds Mean server latency in seconds.
+# TYPE dynomite_latency_mean_seconds gauge
+dynomite_latency_mean_seconds{rack="rack-1"} 0.00012
+# HELP dynomite_latency_max_seconds Maximum server latency in seconds.
+# TYPE dynomite_latency_max_seconds gauge
+dynomite_latency_max_seconds{rack="rack-1"} 0.0015
+# HELP dynomite_payload_size_bytes Payload size in bytes, by quantile.
+# TYPE dynomite_payload_size_bytes gauge
+dynomite_payload_size_bytes{quantile="0.95",rack="rack-1"} 512
+dynomite_payload_size_bytes{quantile="0.99",rack="rack-1"} 1024
+dynomite_payload_size_bytes{quantile="0.999",rack="rack-1"} 2048
+# HELP dynomite_client_out_queue Client out queue.
+# TYPE dynomite_client_out_queue gauge
+dynomite_client_out_queue{rack="rack-1",type="99"} 4
+# HELP dynomite_dyn_memory_bytes Dynomite memory usage in bytes.
+# TYPE dynomite_dyn_memory_bytes gauge
+dynomite_dyn_memory_bytes{rack="rack-1"} 1.048576e+06
+# HELP dynomite_pool_client_connections Number of active client connections.
+# TYPE dynomite_pool_client_connections gauge
+dynomite_pool_client_connections{pool="dyn_o_mite",rack="rack-1"} 12
+# HELP dynomite_pool_server_read_requests_total Number of read requests sent to the server.
+# TYPE dynomite_pool_server_read_requests_total counter
+dynomite_pool_server_read_requests_total{pool="dyn_o_mite",rack="rack-1",server="127.0.0.1:6379"} 800
+# HELP dynomite_pool_server_ejected_at_timestamp_seconds Time the server was last ejected, in seconds since the epoch.
+# TYPE dynomite_pool_server_ejected_at_timestamp_seconds gauge
+dynomite_pool_server_ejected_at_timestamp_seconds{pool="dyn_o_mite",rack="rack-1",server="127.0.0.1:6379"} 1.5e+09
+# HELP dynomite_dnode_peer_connections Number of active connections to the peer. Local dc peers only.
+# TYPE dynomite_dnode_peer_connections gauge
+dynomite_dnode_peer_connections{peer="dynomite-2",peer_dc="us-east-1",peer_rack="rack-2",pool="dyn_o_mite",rack="rack-1"} 2
+# HELP dynomite_dnode_remote_peer_connections Number of active connections to the peer. Remote dc peers only.
+# TYPE dynomite_dnode_remote_peer_connections gauge
+dynomite_dnode_remote_peer_connections{peer="dynomite-3",peer_dc="us-west-2",peer_rack="rack-1",pool="dyn_o_mite",rack="rack-1"} 3
+# HELP dynomite_latency Server latency in microseconds. Deprecated, use dynomite_latency_seconds.
+# TYPE dynomite_latency counter
+dynomite_latency{rack="rack-1",type="50"} 120
+dynomite_latency{rack="rack-1",type="95"} 300
+dynomite_latency{rack="rack-1",type="99"} 600
+dynomite_latency{rack="rack-1",type="999"} 900
+dynomite_latency{rack="rack-1",type="max"} 1500
+# HELP dynomite_dyn_memory Dynomite memory usage. Deprecated, use dynomite_dyn_memory_bytes.
+# TYPE dynomite_dyn_memory gauge
+dynomite_dyn_memory{rack="rack-1"} 1.048576e+06
+`
+	names := []string{
+		"dynomite_up",
+		"dynomite_latency_seconds",
+		"dynomite_latency_mean_seconds",
+		"dynomite_latency_max_seconds",
+		"dynomite_payload_size_bytes",
+		"dynomite_client_out_queue",
+		"dynomite_dyn_memory_bytes",
+		"dynomite_pool_client_connections",
+		"dynomite_pool_server_read_requests_total",
+		"dynomite_pool_server_ejected_at_timestamp_seconds",
+		"dynomite_dnode_peer_connections",
+		"dynomite_dnode_remote_peer_connections",
+		"dynomite_latency",
+		"dynomite_dyn_memory",
+	}
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExporterCollectDown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	e := New(srv.URL, Options{Client: NewHTTPClient(), Timeout: time.Second}, log.NewNopLogger())
+	expected := `
+# HELP dynomite_up Could the dynomite server be reached.
+# TYPE dynomite_up gauge
+dynomite_up 0
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"reflect"
+	"strings"
+)
+
+// scope tells which part of the stats document a metric is read from.
+type scope int
+
+const (
+	// scopeNode metrics are read from the top level of the stats document,
+	// once per scrape.
+	scopeNode scope = iota
+	// scopePool metrics are read from every pool object of the stats
+	// document and labelled by the pool name.
+	scopePool
+	// scopeServer metrics are read from every datastore server object of
+	// a pool and labelled by the pool and server names.
+	scopeServer
+	// scopePeer metrics are read from every dnode peer object of a pool
+	// and labelled by the pool name and the peer name, dc and rack. Peers
+	// outside the local dc are exported under the name prefixed with
+	// "remote_".
+	scopePeer
+)
+
+// unit is the unit dynomite reports a value in.
+type unit int
+
+const (
+	unitNone unit = iota
+	// unitMicroseconds values are converted to seconds.
+	unitMicroseconds
+)
+
+// metricDef describes how a value of the stats document is exported.
+type metricDef struct {
+	scope scope
+	// path is the dot separated JSON path of the value, relative to the
+	// object the scope refers to.
+	path      string
+	subsystem string
+	name      string
+	help      string
+	valueType prometheus.ValueType
+	unit      unit
+	// labels are fixed label values distinguishing metrics sharing a name.
+	labels prometheus.Labels
+}
+
+// metricDefs lists every metric exported from the stats document. Metrics
+// sharing a name must share help, type and label names.
+var metricDefs = []metricDef{
+	// Node statistics.
+	{path: "uptime", name: "uptime_seconds", help: "Number of seconds since the server started.", valueType: prometheus.GaugeValue},
+	{path: "latency_max", name: "latency_max_seconds", help: "Maximum server latency in seconds.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+	{path: "latency_999th", name: "latency_seconds", help: "Server latency in seconds, by quantile.", valueType: prometheus.GaugeValue, unit: unitMicroseconds, labels: prometheus.Labels{"quantile": "0.999"}},
+	{path: "latency_99th", name: "latency_seconds", help: "Server latency in seconds, by quantile.", valueType: prometheus.GaugeValue, unit: unitMicroseconds, labels: prometheus.Labels{"quantile": "0.99"}},
+	{path: "latency_95th", name: "latency_seconds", help: "Server latency in seconds, by quantile.", valueType: prometheus.GaugeValue, unit: unitMicroseconds, labels: prometheus.Labels{"quantile": "0.95"}},
+	{path: "latency_mean", name: "latency_mean_seconds", help: "Mean server latency in seconds.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+	{path: "payload_size_max", name: "payload_size_max_bytes", help: "Maximum payload size in bytes.", valueType: prometheus.GaugeValue},
+	{path: "payload_size_999th", name: "payload_size_bytes", help: "Payload size in bytes, by quantile.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"quantile": "0.999"}},
+	{path: "payload_size_99th", name: "payload_size_bytes", help: "Payload size in bytes, by quantile.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"quantile": "0.99"}},
+	{path: "payload_size_95th", name: "payload_size_bytes", help: "Payload size in bytes, by quantile.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"quantile": "0.95"}},
+	{path: "payload_size_mean", name: "payload_size_mean_bytes", help: "Mean payload size in bytes.", valueType: prometheus.GaugeValue},
+	{path: "99_cross_region_rtt", name: "cross_region_rtt_seconds", help: "Cross region round trip time in seconds, by quantile.", valueType: prometheus.GaugeValue, unit: unitMicroseconds, labels: prometheus.Labels{"quantile": "0.99"}},
+	{path: "average_cross_region_rtt", name: "cross_region_rtt_mean_seconds", help: "Mean cross region round trip time in seconds.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+	{path: "99_cross_zone_latency", name: "cross_zone_latency_seconds", help: "Cross zone latency in seconds, by quantile.", valueType: prometheus.GaugeValue, unit: unitMicroseconds, labels: prometheus.Labels{"quantile": "0.99"}},
+	{path: "average_cross_zone_latency", name: "cross_zone_latency_mean_seconds", help: "Mean cross zone latency in seconds.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+	{path: "99_server_latency", name: "server_latency_seconds", help: "Datastore server latency in seconds, by quantile.", valueType: prometheus.GaugeValue, unit: unitMicroseconds, labels: prometheus.Labels{"quantile": "0.99"}},
+	{path: "average_server_latency", name: "server_latency_mean_seconds", help: "Mean datastore server latency in seconds.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+	{path: "99_cross_region_queue_wait", name: "cross_region_queue_wait_seconds", help: "Cross region queue wait time in seconds, by quantile.", valueType: prometheus.GaugeValue, unit: unitMicroseconds, labels: prometheus.Labels{"quantile": "0.99"}},
+	{path: "average_cross_region_queue_wait", name: "cross_region_queue_wait_mean_seconds", help: "Mean cross region queue wait time in seconds.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+	{path: "99_cross_zone_queue_wait", name: "cross_zone_queue_wait_seconds", help: "Cross zone queue wait time in seconds, by quantile.", valueType: prometheus.GaugeValue, unit: unitMicroseconds, labels: prometheus.Labels{"quantile": "0.99"}},
+	{path: "average_cross_zone_queue_wait", name: "cross_zone_queue_wait_mean_seconds", help: "Mean cross zone queue wait time in seconds.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+	{path: "99_server_queue_wait", name: "server_queue_wait_seconds", help: "Datastore server queue wait time in seconds, by quantile.", valueType: prometheus.GaugeValue, unit: unitMicroseconds, labels: prometheus.Labels{"quantile": "0.99"}},
+	{path: "average_server_queue_wait", name: "server_queue_wait_mean_seconds", help: "Mean datastore server queue wait time in seconds.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+	{path: "client_out_queue_99", name: "client_out_queue", help: "Client out queue.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "server_in_queue_99", name: "server_in_queue", help: "Server in queue.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "server_out_queue_99", name: "server_out_queue", help: "Server out queue.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "dnode_client_out_queue_99", name: "dnode_client_out_queue", help: "Dnode client out queue.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "peer_in_queue_99", name: "peer_in_queue", help: "Peer in queue.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "peer_out_queue_99", name: "peer_out_queue", help: "Peer out queue.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "remote_peer_in_queue_99", name: "remote_peer_in_queue", help: "Remote peer in queue.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "remote_peer_out_queue_99", name: "remote_peer_out_queue", help: "Remote peer out queue.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "alloc_msgs", name: "alloc_msgs", help: "The number of currently allocated messages.", valueType: prometheus.GaugeValue},
+	{path: "free_msgs", name: "free_msgs", help: "The number of currently free messages.", valueType: prometheus.GaugeValue},
+	{path: "alloc_mbufs", name: "alloc_mbufs", help: "The number of allocated mbufs.", valueType: prometheus.GaugeValue},
+	{path: "free_mbufs", name: "free_mbufs", help: "The number of free mbufs.", valueType: prometheus.GaugeValue},
+	{path: "dyn_memory", name: "dyn_memory_bytes", help: "Dynomite memory usage in bytes.", valueType: prometheus.GaugeValue},
+
+	// Pool statistics.
+	{scope: scopePool, path: "client_eof", subsystem: "pool", name: "client_eof_total", help: "Number of EOFs on client connections.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "client_err", subsystem: "pool", name: "client_errors_total", help: "Number of errors on client connections.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "client_connections", subsystem: "pool", name: "client_connections", help: "Number of active client connections.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "client_read_requests", subsystem: "pool", name: "client_read_requests_total", help: "Number of client read requests.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "client_write_requests", subsystem: "pool", name: "client_write_requests_total", help: "Number of client write requests.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "client_dropped_requests", subsystem: "pool", name: "client_dropped_requests_total", help: "Number of dropped client requests.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "client_non_quorum_w_responses", subsystem: "pool", name: "client_non_quorum_write_responses_total", help: "Number of client write responses that did not reach quorum.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "client_non_quorum_r_responses", subsystem: "pool", name: "client_non_quorum_read_responses_total", help: "Number of client read responses that did not reach quorum.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "server_ejects", subsystem: "pool", name: "server_ejects_total", help: "Number of times a backend server was ejected.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "dnode_client_eof", subsystem: "pool", name: "dnode_client_eof_total", help: "Number of EOFs on dnode client connections.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "dnode_client_err", subsystem: "pool", name: "dnode_client_errors_total", help: "Number of errors on dnode client connections.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "dnode_client_connections", subsystem: "pool", name: "dnode_client_connections", help: "Number of active dnode client connections.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "dnode_client_in_queue", subsystem: "pool", name: "dnode_client_in_queue", help: "Number of dnode client requests in the incoming queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "dnode_client_in_queue_bytes", subsystem: "pool", name: "dnode_client_in_queue_bytes", help: "Size of dnode client requests in the incoming queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "dnode_client_out_queue", subsystem: "pool", name: "dnode_client_out_queue", help: "Number of dnode client requests in the outgoing queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "dnode_client_out_queue_bytes", subsystem: "pool", name: "dnode_client_out_queue_bytes", help: "Size of dnode client requests in the outgoing queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "peer_dropped_requests", subsystem: "pool", name: "peer_dropped_requests_total", help: "Number of requests dropped by local DC peers.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_timedout_requests", subsystem: "pool", name: "peer_timedout_requests_total", help: "Number of requests timed out by local DC peers.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "remote_peer_dropped_requests", subsystem: "pool", name: "remote_peer_dropped_requests_total", help: "Number of requests dropped by remote DC peers.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "remote_peer_timedout_requests", subsystem: "pool", name: "remote_peer_timedout_requests_total", help: "Number of requests timed out by remote DC peers.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "remote_peer_failover_requests", subsystem: "pool", name: "remote_peer_failover_requests_total", help: "Number of requests failed over to another remote DC peer.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_eof", subsystem: "pool", name: "peer_eof_total", help: "Number of EOFs on peer connections.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_err", subsystem: "pool", name: "peer_errors_total", help: "Number of errors on peer connections.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_timedout", subsystem: "pool", name: "peer_timedout_total", help: "Number of timeouts on local DC peer connections.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "remote_peer_timedout", subsystem: "pool", name: "remote_peer_timedout_total", help: "Number of timeouts on remote DC peer connections.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_connections", subsystem: "pool", name: "peer_connections", help: "Number of active peer connections.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "peer_forward_error", subsystem: "pool", name: "peer_forward_errors_total", help: "Number of errors forwarding requests to peers.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_requests", subsystem: "pool", name: "peer_requests_total", help: "Number of peer requests.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_request_bytes", subsystem: "pool", name: "peer_request_bytes_total", help: "Total size of peer requests.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_responses", subsystem: "pool", name: "peer_responses_total", help: "Number of peer responses.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_response_bytes", subsystem: "pool", name: "peer_response_bytes_total", help: "Total size of peer responses.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_ejects", subsystem: "pool", name: "peer_ejects_total", help: "Number of times a peer was ejected.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_in_queue", subsystem: "pool", name: "peer_in_queue", help: "Number of local DC peer requests in the incoming queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "remote_peer_in_queue", subsystem: "pool", name: "remote_peer_in_queue", help: "Number of remote DC peer requests in the incoming queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "peer_in_queue_bytes", subsystem: "pool", name: "peer_in_queue_bytes", help: "Size of local DC peer requests in the incoming queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "remote_peer_in_queue_bytes", subsystem: "pool", name: "remote_peer_in_queue_bytes", help: "Size of remote DC peer requests in the incoming queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "peer_out_queue", subsystem: "pool", name: "peer_out_queue", help: "Number of local DC peer requests in the outgoing queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "remote_peer_out_queue", subsystem: "pool", name: "remote_peer_out_queue", help: "Number of remote DC peer requests in the outgoing queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "peer_out_queue_bytes", subsystem: "pool", name: "peer_out_queue_bytes", help: "Size of local DC peer requests in the outgoing queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "remote_peer_out_queue_bytes", subsystem: "pool", name: "remote_peer_out_queue_bytes", help: "Size of remote DC peer requests in the outgoing queue.", valueType: prometheus.GaugeValue},
+	{scope: scopePool, path: "peer_mismatch_requests", subsystem: "pool", name: "peer_mismatch_requests_total", help: "Number of requests with mismatching local DC peer responses.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "forward_error", subsystem: "pool", name: "forward_errors_total", help: "Number of errors forwarding requests.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "fragments", subsystem: "pool", name: "fragments_total", help: "Number of fragments created from multi-key requests.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "stats_count", subsystem: "pool", name: "stats_requests_total", help: "Number of stats requests served.", valueType: prometheus.CounterValue},
+	{scope: scopePool, path: "peer_ejected_at", subsystem: "pool", name: "peer_ejected_at_timestamp_seconds", help: "Time the last peer was ejected, in seconds since the epoch.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+
+	// Datastore server statistics.
+	{scope: scopeServer, path: "server_eof", subsystem: "pool", name: "server_eof_total", help: "Number of EOFs on server connections.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "server_err", subsystem: "pool", name: "server_errors_total", help: "Number of errors on server connections.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "server_timedout", subsystem: "pool", name: "server_timedout_total", help: "Number of timeouts on server connections.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "server_connections", subsystem: "pool", name: "server_connections", help: "Number of active server connections.", valueType: prometheus.GaugeValue},
+	{scope: scopeServer, path: "server_dropped_requests", subsystem: "pool", name: "server_dropped_requests_total", help: "Number of requests dropped by the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "server_timedout_requests", subsystem: "pool", name: "server_timedout_requests_total", help: "Number of requests timed out by the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "read_requests", subsystem: "pool", name: "server_read_requests_total", help: "Number of read requests sent to the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "read_request_bytes", subsystem: "pool", name: "server_read_request_bytes_total", help: "Total size of read requests sent to the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "write_requests", subsystem: "pool", name: "server_write_requests_total", help: "Number of write requests sent to the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "write_request_bytes", subsystem: "pool", name: "server_write_request_bytes_total", help: "Total size of write requests sent to the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "read_responses", subsystem: "pool", name: "server_read_responses_total", help: "Number of read responses received from the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "read_response_bytes", subsystem: "pool", name: "server_read_response_bytes_total", help: "Total size of read responses received from the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "write_responses", subsystem: "pool", name: "server_write_responses_total", help: "Number of write responses received from the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "write_response_bytes", subsystem: "pool", name: "server_write_response_bytes_total", help: "Total size of write responses received from the server.", valueType: prometheus.CounterValue},
+	{scope: scopeServer, path: "in_queue", subsystem: "pool", name: "server_in_queue", help: "Number of requests in the incoming queue of the server.", valueType: prometheus.GaugeValue},
+	{scope: scopeServer, path: "in_queue_bytes", subsystem: "pool", name: "server_in_queue_bytes", help: "Size of requests in the incoming queue of the server.", valueType: prometheus.GaugeValue},
+	{scope: scopeServer, path: "out_queue", subsystem: "pool", name: "server_out_queue", help: "Number of requests in the outgoing queue of the server.", valueType: prometheus.GaugeValue},
+	{scope: scopeServer, path: "out_queue_bytes", subsystem: "pool", name: "server_out_queue_bytes", help: "Size of requests in the outgoing queue of the server.", valueType: prometheus.GaugeValue},
+	{scope: scopeServer, path: "server_ejected_at", subsystem: "pool", name: "server_ejected_at_timestamp_seconds", help: "Time the server was last ejected, in seconds since the epoch.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+
+	// Dnode peer statistics.
+	{scope: scopePeer, path: "peer_eof", subsystem: "dnode", name: "peer_eof_total", help: "Number of EOFs on connections to the peer.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_err", subsystem: "dnode", name: "peer_errors_total", help: "Number of errors on connections to the peer.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_timedout", subsystem: "dnode", name: "peer_timedout_total", help: "Number of timeouts on connections to the peer.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_connections", subsystem: "dnode", name: "peer_connections", help: "Number of active connections to the peer.", valueType: prometheus.GaugeValue},
+	{scope: scopePeer, path: "peer_dropped_requests", subsystem: "dnode", name: "peer_dropped_requests_total", help: "Number of requests dropped by the peer.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_timedout_requests", subsystem: "dnode", name: "peer_timedout_requests_total", help: "Number of requests timed out by the peer.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_requests", subsystem: "dnode", name: "peer_requests_total", help: "Number of requests sent to the peer.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_request_bytes", subsystem: "dnode", name: "peer_request_bytes_total", help: "Total size of requests sent to the peer.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_responses", subsystem: "dnode", name: "peer_responses_total", help: "Number of responses received from the peer.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_response_bytes", subsystem: "dnode", name: "peer_response_bytes_total", help: "Total size of responses received from the peer.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_ejects", subsystem: "dnode", name: "peer_ejects_total", help: "Number of times the peer was ejected.", valueType: prometheus.CounterValue},
+	{scope: scopePeer, path: "peer_in_queue", subsystem: "dnode", name: "peer_in_queue", help: "Number of requests in the incoming queue of the peer.", valueType: prometheus.GaugeValue},
+	{scope: scopePeer, path: "peer_in_queue_bytes", subsystem: "dnode", name: "peer_in_queue_bytes", help: "Size of requests in the incoming queue of the peer.", valueType: prometheus.GaugeValue},
+	{scope: scopePeer, path: "peer_out_queue", subsystem: "dnode", name: "peer_out_queue", help: "Number of requests in the outgoing queue of the peer.", valueType: prometheus.GaugeValue},
+	{scope: scopePeer, path: "peer_out_queue_bytes", subsystem: "dnode", name: "peer_out_queue_bytes", help: "Size of requests in the outgoing queue of the peer.", valueType: prometheus.GaugeValue},
+	{scope: scopePeer, path: "peer_ejected_at", subsystem: "dnode", name: "peer_ejected_at_timestamp_seconds", help: "Time the peer was last ejected, in seconds since the epoch.", valueType: prometheus.GaugeValue, unit: unitMicroseconds},
+}
+
+// legacyMetricDefs lists the metrics renamed when converting them to base
+// units under their old names and in the units dynomite reports them in. They
+// are only exported in compatibility mode and will be removed in a future
+// release.
+var legacyMetricDefs = []metricDef{
+	{path: "latency_max", name: "latency", help: "Server latency in microseconds. Deprecated, use dynomite_latency_seconds.", valueType: prometheus.CounterValue, labels: prometheus.Labels{"type": "max"}},
+	{path: "latency_999th", name: "latency", help: "Server latency in microseconds. Deprecated, use dynomite_latency_seconds.", valueType: prometheus.CounterValue, labels: prometheus.Labels{"type": "999"}},
+	{path: "latency_99th", name: "latency", help: "Server latency in microseconds. Deprecated, use dynomite_latency_seconds.", valueType: prometheus.CounterValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "latency_95th", name: "latency", help: "Server latency in microseconds. Deprecated, use dynomite_latency_seconds.", valueType: prometheus.CounterValue, labels: prometheus.Labels{"type": "95"}},
+	{path: "latency_mean", name: "latency", help: "Server latency in microseconds. Deprecated, use dynomite_latency_seconds.", valueType: prometheus.CounterValue, labels: prometheus.Labels{"type": "50"}},
+	{path: "payload_size_max", name: "payload_size", help: "Payload size. Deprecated, use dynomite_payload_size_bytes.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "max"}},
+	{path: "payload_size_999th", name: "payload_size", help: "Payload size. Deprecated, use dynomite_payload_size_bytes.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "999"}},
+	{path: "payload_size_99th", name: "payload_size", help: "Payload size. Deprecated, use dynomite_payload_size_bytes.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "payload_size_95th", name: "payload_size", help: "Payload size. Deprecated, use dynomite_payload_size_bytes.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "95"}},
+	{path: "payload_size_mean", name: "payload_size", help: "Payload size. Deprecated, use dynomite_payload_size_bytes.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "50"}},
+	{path: "99_cross_region_rtt", name: "cross_region_rtt", help: "Cross region RTT in microseconds. Deprecated, use dynomite_cross_region_rtt_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "average_cross_region_rtt", name: "cross_region_rtt", help: "Cross region RTT in microseconds. Deprecated, use dynomite_cross_region_rtt_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "50"}},
+	{path: "99_cross_zone_latency", name: "cross_zone_latency", help: "Cross zone latency in microseconds. Deprecated, use dynomite_cross_zone_latency_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "average_cross_zone_latency", name: "cross_zone_latency", help: "Cross zone latency in microseconds. Deprecated, use dynomite_cross_zone_latency_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "50"}},
+	{path: "99_server_latency", name: "server_latency", help: "Server latency in microseconds. Deprecated, use dynomite_server_latency_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "average_server_latency", name: "server_latency", help: "Server latency in microseconds. Deprecated, use dynomite_server_latency_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "50"}},
+	{path: "99_cross_region_queue_wait", name: "cross_region_queue_wait", help: "Cross region queue wait in microseconds. Deprecated, use dynomite_cross_region_queue_wait_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "average_cross_region_queue_wait", name: "cross_region_queue_wait", help: "Cross region queue wait in microseconds. Deprecated, use dynomite_cross_region_queue_wait_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "50"}},
+	{path: "99_cross_zone_queue_wait", name: "cross_zone_queue_wait", help: "Cross zone queue wait in microseconds. Deprecated, use dynomite_cross_zone_queue_wait_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "average_cross_zone_queue_wait", name: "cross_zone_queue_wait", help: "Cross zone queue wait in microseconds. Deprecated, use dynomite_cross_zone_queue_wait_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "50"}},
+	{path: "99_server_queue_wait", name: "server_queue_wait", help: "Server queue wait in microseconds. Deprecated, use dynomite_server_queue_wait_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "99"}},
+	{path: "average_server_queue_wait", name: "server_queue_wait", help: "Server queue wait in microseconds. Deprecated, use dynomite_server_queue_wait_seconds.", valueType: prometheus.GaugeValue, labels: prometheus.Labels{"type": "50"}},
+	{path: "dyn_memory", name: "dyn_memory", help: "Dynomite memory usage. Deprecated, use dynomite_dyn_memory_bytes.", valueType: prometheus.GaugeValue},
+}
+
+// value converts v, as reported by dynomite, to the base unit of the metric.
+func (d *metricDef) value(v float64) float64 {
+	switch d.unit {
+	case unitMicroseconds:
+		return v / 1e6
+	}
+	return v
+}
+
+// fieldIndex returns the index sequence of the numeric field found at the dot
+// separated JSON path in the struct type t.
+func fieldIndex(t reflect.Type, path string) ([]int, error) {
+	var index []int
+	for _, key := range strings.Split(path, ".") {
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("stats path %q: %s is not an object", path, t)
+		}
+		f, ok := jsonField(t, key)
+		if !ok {
+			return nil, fmt.Errorf("stats path %q: %s has no field %q", path, t, key)
+		}
+		index = append(index, f.Index...)
+		t = f.Type
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return index, nil
+	}
+	return nil, fmt.Errorf("stats path %q: %s is not a number", path, t)
+}
+
+// jsonField returns the field of the struct type t decoded from key.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if strings.Split(f.Tag.Get("json"), ",")[0] == key {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// number returns the numeric value v as a float.
+func number(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// snapshot holds the metrics of a background poll.
+type snapshot struct {
+	metrics []prometheus.Metric
+	time    time.Time
+}
+
+// Start starts polling the dynomite server in the background, if the exporter
+// was created with a poll interval, until Stop is called. Start must be
+// called at most once.
+func (e *Exporter) Start() {
+	if e.pollInterval == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.stop = cancel
+	go e.poll(ctx)
+}
+
+// Stop stops polling the dynomite server.
+func (e *Exporter) Stop() {
+	if e.stop != nil {
+		e.stop()
+	}
+}
+
+// poll scrapes the dynomite server every poll interval, keeping the metrics
+// of each scrape as the snapshot served, until ctx is done.
+func (e *Exporter) poll(ctx context.Context) {
+	ticker := time.NewTicker(e.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		ch := make(chan prometheus.Metric)
+		done := make(chan []prometheus.Metric)
+		go func() {
+			var metrics []prometheus.Metric
+			for m := range ch {
+				metrics = append(metrics, m)
+			}
+			done <- metrics
+		}()
+
+		start := time.Now()
+		e.scrape(ctx, ch)
+		close(ch)
+		metrics := <-done
+
+		if ctx.Err() != nil {
+			return
+		}
+		e.mtx.Lock()
+		e.snapshot = &snapshot{metrics: metrics, time: start}
+		e.mtx.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collectSnapshot sends the metrics of the last poll, and their age. Nothing
+// is sent before the first poll completes, so that a target just added is not
+// reported down.
+func (e *Exporter) collectSnapshot(ch chan<- prometheus.Metric) {
+	e.mtx.Lock()
+	s := e.snapshot
+	e.mtx.Unlock()
+
+	if s == nil {
+		return
+	}
+	for _, m := range s.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(e.snapshotAge, prometheus.GaugeValue, time.Since(s.time).Seconds())
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExporterCollectSnapshot(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"service": "dynomite", "rack": "rack-1", "uptime": 42}`))
+	}))
+	defer srv.Close()
+
+	e := New(srv.URL, Options{
+		Client:       NewHTTPClient(),
+		Timeout:      time.Second,
+		PollInterval: time.Hour,
+	}, log.NewNopLogger())
+
+	// Nothing is exported before the first poll.
+	if n := testutil.CollectAndCount(e); n != 0 {
+		t.Fatalf("%d metrics collected before the first poll, want none", n)
+	}
+
+	e.Start()
+	defer e.Stop()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		e.mtx.Lock()
+		polled := e.snapshot != nil
+		e.mtx.Unlock()
+		if polled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no poll completed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Collecting serves the snapshot without requesting the node.
+	expected := `
+# HELP dynomite_up Could the dynomite server be reached.
+# TYPE dynomite_up gauge
+dynomite_up 1
+# HELP dynomite_uptime_seconds Number of seconds since the server started.
+# TYPE dynomite_uptime_seconds gauge
+dynomite_uptime_seconds{rack="rack-1"} 42
+`
+	for i := 0; i < 2; i++ {
+		if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "dynomite_up", "dynomite_uptime_seconds"); err != nil {
+			t.Error(err)
+		}
+	}
+	if n := testutil.CollectAndCount(e, "dynomite_exporter_snapshot_age_seconds"); n != 1 {
+		t.Errorf("%d snapshot age metrics, want 1", n)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("%d requests, want only the poll", n)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states, as exported in the state label.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+var breakerStates = []string{breakerClosed, breakerOpen, breakerHalfOpen}
+
+// breaker is a circuit breaker that opens after a number of consecutive
+// failed scrapes of a target. While open, the target is not requested. After
+// the cooldown, a single trial scrape is let through half open, closing the
+// breaker if it succeeds and opening it again otherwise. A nil breaker never
+// opens.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mtx        sync.Mutex
+	state      string
+	failures   int
+	openedAt   time.Time
+	recordedAt time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{threshold: threshold, cooldown: cooldown, state: breakerClosed}
+}
+
+// allow reports whether the target may be requested.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// A trial scrape is in flight.
+		return false
+	}
+	return true
+}
+
+// record records the outcome of a scrape let through by allow, and returns
+// the state of the breaker and whether it changed.
+func (b *breaker) record(err error) (string, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	previous := b.state
+	b.recordedAt = time.Now()
+	if err == nil {
+		b.failures = 0
+		b.state = breakerClosed
+	} else {
+		b.failures++
+		if b.state == breakerHalfOpen || b.failures >= b.threshold {
+			b.state = breakerOpen
+			b.openedAt = time.Now()
+		}
+	}
+	return b.state, b.state != previous
+}
+
+// release undoes allow for a scrape whose outcome is not recorded, so that
+// the next scrape is the trial if this one was.
+func (b *breaker) release() {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+// idle reports whether the breaker is closed without failures, or has not
+// recorded a scrape for ttl, so that dropping it loses nothing of interest.
+func (b *breaker) idle(ttl time.Duration) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return (b.state == breakerClosed && b.failures == 0) || time.Since(b.recordedAt) > ttl
+}
+
+// current returns the state of the breaker.
+func (b *breaker) current() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.state
+}
+
+// retryable reports whether a scrape failing with err may succeed when
+// retried. An undecodable stats document is not expected to change.
+func retryable(err error) bool {
+	var scrapeErr *ScrapeError
+	return !errors.As(err, &scrapeErr) || scrapeErr.Reason != ReasonDecode
+}
+
+// backoff returns the time to wait before retry attempt, counted from 0: base
+// doubled for every previous attempt, with jitter drawing it from the upper
+// half of that range.
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << uint(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for d, returning false without waiting if ctx would be done
+// first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// errScrapeEnded is returned by fetchMetrics when the scrape of the exporter
+// ended before the node answered or timed out.
+var errScrapeEnded = errors.New("scrape ended before the node answered")
+
+// fetchMetrics gets the stats of the node, retrying failed requests within the
+// timeout, unless the circuit breaker is open. If ctx is done first, the
+// failure tells nothing of the node: it is not recorded by the breaker and
+// errScrapeEnded is returned.
+func (e *Exporter) fetchMetrics(ctx context.Context) (DynomiteMetrics, int64, error) {
+	if ctx.Err() != nil {
+		return DynomiteMetrics{}, 0, errScrapeEnded
+	}
+	if !e.breaker.allow() {
+		return DynomiteMetrics{}, 0, &ScrapeError{Reason: ReasonCircuitOpen, Err: errors.New("circuit breaker open")}
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	stats, size, err := getMetrics(timeoutCtx, e.client, e.target.Address)
+	for attempt := 0; err != nil && attempt < e.retries && retryable(err); attempt++ {
+		if !sleep(timeoutCtx, backoff(e.retryBackoff, attempt)) {
+			break
+		}
+		level.Debug(e.logger).Log("msg", "Retrying scrape of dynomite", "attempt", attempt+1, "err", err)
+		stats, size, err = getMetrics(timeoutCtx, e.client, e.target.Address)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		e.breaker.release()
+		return DynomiteMetrics{}, 0, errScrapeEnded
+	}
+	if e.breaker != nil {
+		if state, changed := e.breaker.record(err); changed {
+			level.Warn(e.logger).Log("msg", "Circuit breaker changed state", "state", state)
+		}
+	}
+	return stats, size, err
+}
+
+// collectBreaker exports the state of the circuit breaker as an enum.
+func (e *Exporter) collectBreaker(ch chan<- prometheus.Metric) {
+	current := e.breaker.current()
+	for _, s := range breakerStates {
+		v := float64(0)
+		if s == current {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(e.breakerState, prometheus.GaugeValue, v, s)
+	}
+}
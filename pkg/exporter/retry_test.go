@@ -0,0 +1,227 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	errScrape := errors.New("connection refused")
+	b := newBreaker(2, time.Minute)
+
+	steps := []struct {
+		name      string
+		err       error
+		wantState string
+		wantAllow bool
+	}{
+		{"first failure", errScrape, breakerClosed, true},
+		{"threshold reached", errScrape, breakerOpen, false},
+		{"trial fails", errScrape, breakerOpen, false},
+		{"trial succeeds", nil, breakerClosed, true},
+	}
+	for i, s := range steps {
+		if i >= 2 {
+			// The cooldown elapses, and a single trial scrape is let
+			// through.
+			b.openedAt = time.Now().Add(-time.Minute)
+			if !b.allow() {
+				t.Fatalf("%s: allow() = false after the cooldown", s.name)
+			}
+			if b.current() != breakerHalfOpen || b.allow() {
+				t.Fatalf("%s: want a single trial scrape half open", s.name)
+			}
+		} else if !b.allow() {
+			t.Fatalf("%s: allow() = false", s.name)
+		}
+		if state, _ := b.record(s.err); state != s.wantState {
+			t.Errorf("%s: state = %s, want %s", s.name, state, s.wantState)
+		}
+		if got := b.allow(); got != s.wantAllow {
+			t.Errorf("%s: allow() = %v, want %v", s.name, got, s.wantAllow)
+		}
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(0, time.Minute)
+	if b != nil {
+		t.Fatalf("newBreaker(0) = %v, want nil", b)
+	}
+	if !b.allow() {
+		t.Error("allow() = false on a nil breaker")
+	}
+}
+
+func TestBreakerIdle(t *testing.T) {
+	b := newBreaker(1, time.Minute)
+	if !b.idle(time.Hour) {
+		t.Error("idle() = false for a new breaker")
+	}
+	b.record(errors.New("timeout"))
+	if b.idle(time.Hour) {
+		t.Error("idle() = true for an open breaker")
+	}
+	b.recordedAt = time.Now().Add(-2 * time.Hour)
+	if !b.idle(time.Hour) {
+		t.Error("idle() = false for a breaker not recorded for longer than the ttl")
+	}
+}
+
+func TestProbeBreaker(t *testing.T) {
+	c := NewTargetCollector(Options{
+		Client:           NewHTTPClient(),
+		Timeout:          time.Second,
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Minute,
+	}, log.NewNopLogger())
+	c.SetTargets([]Target{{Name: "dynomite-1", Address: "dynomite-1:22222"}})
+
+	configured := c.NewExporter(Target{Name: "dynomite-1", Address: "dynomite-1:22222"}, nil)
+	if configured.breaker == nil || configured.breaker != c.exporters["dynomite-1"].breaker {
+		t.Error("probe of a configured target does not share its breaker")
+	}
+
+	probed := c.NewExporter(Target{Name: "dynomite-2:22222", Address: "dynomite-2:22222"}, nil)
+	again := c.NewExporter(Target{Name: "dynomite-2:22222", Address: "dynomite-2:22222"}, nil)
+	if probed.breaker == nil || probed.breaker != again.breaker {
+		t.Error("probes of an address do not share a breaker")
+	}
+
+	// A failing address keeps its breaker, an idle one is dropped when
+	// another address is probed.
+	probed.breaker.record(errors.New("timeout"))
+	c.NewExporter(Target{Name: "dynomite-3:22222", Address: "dynomite-3:22222"}, nil)
+	c.NewExporter(Target{Name: "dynomite-4:22222", Address: "dynomite-4:22222"}, nil)
+	if _, ok := c.probeBreakers["dynomite-2:22222"]; !ok {
+		t.Error("breaker of a failing address was dropped")
+	}
+	if _, ok := c.probeBreakers["dynomite-3:22222"]; ok {
+		t.Error("breaker of an idle address was kept")
+	}
+}
+
+// collectAll returns the metrics c collects within ctx.
+func collectAll(ctx context.Context, c ContextCollector) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	c.CollectContext(ctx, ch)
+	close(ch)
+	return <-done
+}
+
+func TestCollectAfterScrapeEnded(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte(`{"service": "dynomite"}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		ctx          func() (context.Context, context.CancelFunc)
+		wantRequests int32
+	}{
+		{
+			name: "ended before the turn of the target",
+			path: "/",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			wantRequests: 0,
+		},
+		{
+			name: "ended while requesting the target",
+			path: "/slow",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			scrapeMetrics := NewScrapeMetrics()
+			c := NewTargetCollector(Options{
+				Client:           NewHTTPClient(),
+				Timeout:          5 * time.Second,
+				ScrapeMetrics:    scrapeMetrics,
+				BreakerThreshold: 1,
+				BreakerCooldown:  time.Minute,
+			}, log.NewNopLogger())
+			c.SetTargets([]Target{{Name: "dynomite-1", Address: srv.URL + tt.path}})
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			collectAll(ctx, c)
+
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			if got := c.exporters["dynomite-1"].breaker.current(); got != breakerClosed {
+				t.Errorf("breaker state = %s, want %s", got, breakerClosed)
+			}
+			if n := testutil.CollectAndCount(scrapeMetrics.errors); n != 0 {
+				t.Errorf("%d scrape errors counted, want none", n)
+			}
+
+			// The next scrape is not affected.
+			collectAll(context.Background(), c)
+			if got := c.exporters["dynomite-1"].breaker.current(); got != breakerClosed {
+				t.Errorf("breaker state after a full scrape = %s, want %s", got, breakerClosed)
+			}
+		})
+	}
+}
+
+func TestBreakerRelease(t *testing.T) {
+	b := newBreaker(1, time.Minute)
+	b.record(errors.New("timeout"))
+	b.openedAt = time.Now().Add(-time.Minute)
+	if !b.allow() {
+		t.Fatal("allow() = false after the cooldown")
+	}
+	b.release()
+	if got := b.current(); got != breakerOpen {
+		t.Errorf("state after release = %s, want %s", got, breakerOpen)
+	}
+	if !b.allow() {
+		t.Error("allow() = false, want the next scrape to be the trial")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// ScrapeMetrics instruments the scrapes of dynomite nodes by the exporter,
+// labelled by target. A single ScrapeMetrics is shared by all exporters and
+// registered once, so the values outlive the exporters created per probe.
+type ScrapeMetrics struct {
+	duration     *prometheus.GaugeVec
+	errors       *prometheus.CounterVec
+	responseSize *prometheus.GaugeVec
+	lastSuccess  *prometheus.GaugeVec
+	skipped      *prometheus.CounterVec
+}
+
+// NewScrapeMetrics returns initialized scrape metrics.
+func NewScrapeMetrics() *ScrapeMetrics {
+	return &ScrapeMetrics{
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "scrape_duration_seconds",
+			Help:      "Duration of the last scrape of the dynomite stats endpoint.",
+		}, []string{"target"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "scrape_errors_total",
+			Help:      "Number of failed scrapes of the dynomite stats endpoint, by reason.",
+		}, []string{"target", "reason"}),
+		responseSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "scrape_response_size_bytes",
+			Help:      "Size of the last stats document received from the dynomite stats endpoint.",
+		}, []string{"target"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "last_scrape_success_timestamp_seconds",
+			Help:      "Time of the last successful scrape of the dynomite stats endpoint, in seconds since the epoch.",
+		}, []string{"target"}),
+		skipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "skipped_fields_total",
+			Help:      "Number of statistics in stats documents that could not be decoded and were exported as zero.",
+		}, []string{"target"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *ScrapeMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.duration.Describe(ch)
+	m.errors.Describe(ch)
+	m.responseSize.Describe(ch)
+	m.lastSuccess.Describe(ch)
+	m.skipped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *ScrapeMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.duration.Collect(ch)
+	m.errors.Collect(ch)
+	m.responseSize.Collect(ch)
+	m.lastSuccess.Collect(ch)
+	m.skipped.Collect(ch)
+}
+
+// observe records a scrape of target that started at start, received size
+// bytes and failed with err, if not nil. It is a no-op on a nil m.
+func (m *ScrapeMetrics) observe(target string, start time.Time, size int64, err error) {
+	if m == nil {
+		return
+	}
+
+	m.duration.WithLabelValues(target).Set(time.Since(start).Seconds())
+	m.responseSize.WithLabelValues(target).Set(float64(size))
+	if err == nil {
+		m.lastSuccess.WithLabelValues(target).Set(float64(time.Now().Unix()))
+		return
+	}
+
+	reason := ReasonDial
+	var scrapeErr *ScrapeError
+	if errors.As(err, &scrapeErr) {
+		reason = scrapeErr.Reason
+	}
+	m.errors.WithLabelValues(target, reason).Inc()
+}
+
+// skip records that n statistics of a stats document of target could not be
+// decoded. It is a no-op on a nil m.
+func (m *ScrapeMetrics) skip(target string, n int) {
+	if m == nil {
+		return
+	}
+	m.skipped.WithLabelValues(target).Add(float64(n))
+}
+
+// forget removes the series of target, which is no longer scraped. It is a
+// no-op on a nil m.
+func (m *ScrapeMetrics) forget(target string) {
+	if m == nil {
+		return
+	}
+
+	m.duration.DeleteLabelValues(target)
+	m.responseSize.DeleteLabelValues(target)
+	m.lastSuccess.DeleteLabelValues(target)
+	m.skipped.DeleteLabelValues(target)
+	for _, reason := range []string{ReasonDial, ReasonTimeout, ReasonHTTPStatus, ReasonDecode, ReasonCircuitOpen} {
+		m.errors.DeleteLabelValues(target, reason)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// nodeStates are the states a dynomite node can be in. A node that reports a
+// state not listed here is exported with that state only.
+var nodeStates = []string{
+	"INIT",
+	"STANDBY",
+	"WRITES_ONLY",
+	"RESUMING",
+	"NORMAL",
+	"JOINING",
+	"DOWN",
+	"RESET",
+	"UNKNOWN",
+}
+
+// GetState fetches the state of the node whose stats are served at address
+// from its /state/get_state admin endpoint.
+func GetState(ctx context.Context, client *http.Client, address string) (string, error) {
+	body, err := getAdmin(ctx, client, address, "/state/get_state")
+	if err != nil {
+		return "", err
+	}
+	return parseState(body)
+}
+
+// parseState extracts the node state from a state response, which depending
+// on the dynomite version is either a JSON object with a state member or a
+// "State: NORMAL" line.
+func parseState(body []byte) (string, error) {
+	text := strings.TrimSpace(string(body))
+
+	if strings.HasPrefix(text, "{") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &fields); err != nil {
+			return "", err
+		}
+		for k, v := range fields {
+			if s, ok := v.(string); ok && strings.EqualFold(k, "state") {
+				text = s
+				break
+			}
+		}
+	} else if i := strings.LastIndex(text, ":"); i >= 0 {
+		text = text[i+1:]
+	}
+
+	state := strings.ToUpper(strings.TrimSpace(text))
+	if state == "" || strings.ContainsAny(state, "{}\n") {
+		return "", fmt.Errorf("no state in response %q", body)
+	}
+	return state, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"testing"
+)
+
+func TestParseState(t *testing.T) {
+	tests := []struct {
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{body: "State: NORMAL\n", want: "NORMAL"},
+		{body: "state: writes_only", want: "WRITES_ONLY"},
+		{body: "STANDBY", want: "STANDBY"},
+		{body: `{"state": "normal"}`, want: "NORMAL"},
+		{body: `{"State": "JOINING", "rack": "rack-1"}`, want: "JOINING"},
+		{body: `{"status": "ok"}`, wantErr: true},
+		{body: `{"state": `, wantErr: true},
+		{body: "", wantErr: true},
+		{body: "State:", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseState([]byte(tt.body))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseState(%q) error = %v, wantErr %v", tt.body, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseState(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
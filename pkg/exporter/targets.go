@@ -0,0 +1,295 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/config"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// labelNameRE matches valid Prometheus label names.
+var labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// reservedLabels are the label names used by the metrics of an Exporter,
+// which target labels must not override.
+var reservedLabels = map[string]bool{
+	"target":    true,
+	"rack":      true,
+	"type":      true,
+	"server":    true,
+	"peer":      true,
+	"peer_dc":   true,
+	"peer_rack": true,
+	"pool":      true,
+	"quantile":  true,
+	"state":     true,
+	"dc":        true,
+	"host":      true,
+	"token":     true,
+}
+
+// ReservedLabel reports whether name is a label of the metrics of an Exporter,
+// which target labels must not use.
+func ReservedLabel(name string) bool {
+	return reservedLabels[name]
+}
+
+// Target is a dynomite node scraped by a TargetCollector.
+type Target struct {
+	// Name identifies the target in logs and in the target label of its
+	// metrics.
+	Name    string
+	Address string
+	// Timeout overrides the timeout of the collector options, if not zero.
+	Timeout time.Duration
+	// Labels are attached to every metric of the target.
+	Labels map[string]string
+	// HTTPClientConfig, if not nil, configures TLS, authentication and the
+	// proxy for requesting the target, see NewHTTPClientFromConfig.
+	HTTPClientConfig *config.HTTPClientConfig
+}
+
+// Validate checks that t can be scraped and its labels exported.
+func (t Target) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("target %q has no name", t.Address)
+	}
+	if t.Address == "" {
+		return fmt.Errorf("target %q has no address", t.Name)
+	}
+	for name := range t.Labels {
+		if !labelNameRE.MatchString(name) {
+			return fmt.Errorf("target %q: invalid label name %q", t.Name, name)
+		}
+		if reservedLabels[name] {
+			return fmt.Errorf("target %q: label name %q is reserved", t.Name, name)
+		}
+	}
+	if t.HTTPClientConfig != nil {
+		if _, err := NewHTTPClientFromConfig(t.HTTPClientConfig); err != nil {
+			return fmt.Errorf("target %q: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// TargetCollector scrapes a set of dynomite nodes that can be replaced at any
+// time, exporting the metrics of each under its target label.
+type TargetCollector struct {
+	opts   Options
+	logger log.Logger
+
+	mtx       sync.RWMutex
+	targets   []Target
+	exporters map[string]*Exporter
+	// probeBreakers holds the circuit breakers of probed addresses that are
+	// not targets of the collector, by name.
+	probeBreakers map[string]*breaker
+}
+
+// probeBreakerTTL is how long the circuit breaker of a probed address is kept
+// beyond its cooldown once the address is no longer probed.
+const probeBreakerTTL = 10 * time.Minute
+
+// NewTargetCollector returns a collector for an initially empty set of
+// targets, scraping them with opts.
+func NewTargetCollector(opts Options, logger log.Logger) *TargetCollector {
+	return &TargetCollector{
+		opts:          opts,
+		logger:        logger,
+		exporters:     make(map[string]*Exporter),
+		probeBreakers: make(map[string]*breaker),
+	}
+}
+
+// SetTargets replaces the scraped targets. Exporters of unchanged targets are
+// kept, and scrapes in flight complete against the previous set. In polling
+// mode, the new exporters are started and the replaced ones stopped.
+func (c *TargetCollector) SetTargets(targets []Target) {
+	exporters := make(map[string]*Exporter, len(targets))
+	var started []*Exporter
+
+	c.mtx.RLock()
+	for _, t := range targets {
+		var b *breaker
+		if e, ok := c.exporters[t.Name]; ok {
+			if reflect.DeepEqual(e.target, t) {
+				exporters[t.Name] = e
+				continue
+			}
+			b = e.breaker
+		}
+		e := c.newExporter(t, true, b)
+		exporters[t.Name] = e
+		started = append(started, e)
+	}
+	c.mtx.RUnlock()
+
+	c.mtx.Lock()
+	for name, e := range c.exporters {
+		if exporters[name] != e {
+			e.Stop()
+		}
+		if _, ok := exporters[name]; !ok {
+			c.opts.ScrapeMetrics.forget(name)
+		}
+	}
+	c.targets = targets
+	c.exporters = exporters
+	c.mtx.Unlock()
+
+	for _, e := range started {
+		e.Start()
+	}
+}
+
+// Target returns the target named name.
+func (c *TargetCollector) Target(name string) (Target, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	e, ok := c.exporters[name]
+	if !ok {
+		return Target{}, false
+	}
+	return e.target, true
+}
+
+// NewExporter returns an exporter for t, scraping with the options of the
+// collector but recording its scrapes in scrapeMetrics, which may be nil.
+// Unlike the exporters of the collector's targets, its metrics carry no
+// target label, as is expected for probes, and it always scrapes when
+// collected. It shares the circuit breaker of the target of the same name,
+// or of the previous probes of t, so that a node that is down is not
+// requested on every probe.
+func (c *TargetCollector) NewExporter(t Target, scrapeMetrics *ScrapeMetrics) *Exporter {
+	e := c.newExporter(t, false, c.probeBreaker(t.Name))
+	e.scrapeMetrics = scrapeMetrics
+	return e
+}
+
+// probeBreaker returns the circuit breaker for probing the node name, dropping
+// those of probed addresses that became idle.
+func (c *TargetCollector) probeBreaker(name string) *breaker {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if e, ok := c.exporters[name]; ok {
+		return e.breaker
+	}
+	for n, b := range c.probeBreakers {
+		if n != name && b.idle(c.opts.BreakerCooldown+probeBreakerTTL) {
+			delete(c.probeBreakers, n)
+		}
+	}
+	b, ok := c.probeBreakers[name]
+	if !ok {
+		b = newBreaker(c.opts.BreakerThreshold, c.opts.BreakerCooldown)
+		if b != nil {
+			c.probeBreakers[name] = b
+		}
+	}
+	return b
+}
+
+// ScrapeMetrics returns the scrape metrics shared by the exporters of the
+// collector.
+func (c *TargetCollector) ScrapeMetrics() *ScrapeMetrics {
+	return c.opts.ScrapeMetrics
+}
+
+func (c *TargetCollector) newExporter(t Target, targetLabel bool, b *breaker) *Exporter {
+	opts := c.opts
+	if t.Timeout != 0 {
+		opts.Timeout = t.Timeout
+	}
+	if !targetLabel {
+		opts.PollInterval = 0
+	}
+	opts.breaker = b
+	if t.HTTPClientConfig != nil {
+		opts.Client = NewHTTPClientFromLoadedConfig(t.HTTPClientConfig, log.With(c.logger, "target", t.Name))
+	}
+	opts.Labels = make(prometheus.Labels, len(t.Labels)+1)
+	for k, v := range t.Labels {
+		opts.Labels[k] = v
+	}
+	if targetLabel {
+		opts.Labels["target"] = t.Name
+	}
+
+	e := New(t.Address, opts, log.With(c.logger, "target", t.Name))
+	e.target = t
+	return e
+}
+
+// Describe implements prometheus.Collector. It describes no metrics, as the
+// set of targets changes at runtime.
+func (c *TargetCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect scrapes the targets concurrently, at most Options.Concurrency at
+// once. Every target is scraped within its own timeout, and one timing out
+// only sets its up metric to 0. It implements prometheus.Collector.
+func (c *TargetCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is Collect, scraping the targets within the deadline of ctx.
+// Targets whose turn comes after ctx is done are reported down without being
+// requested.
+func (c *TargetCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	c.mtx.RLock()
+	exporters := make([]*Exporter, 0, len(c.targets))
+	for _, t := range c.targets {
+		exporters = append(exporters, c.exporters[t.Name])
+	}
+	c.mtx.RUnlock()
+
+	workers := c.opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(exporters) {
+		workers = len(exporters)
+	}
+
+	work := make(chan *Exporter)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for e := range work {
+				e.CollectContext(ctx, ch)
+			}
+		}()
+	}
+	for _, e := range exporters {
+		if ctx.Err() != nil && e.pollInterval == 0 {
+			// The scrape ended before the turn of the target came.
+			e.scrape(ctx, ch)
+			continue
+		}
+		work <- e
+	}
+	close(work)
+	wg.Wait()
+}
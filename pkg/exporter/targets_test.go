@@ -0,0 +1,125 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTargetCollectorCollect(t *testing.T) {
+	var inFlight, maxInFlight int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+
+		delay := 50 * time.Millisecond
+		if r.URL.Path == "/slow" {
+			delay = time.Minute
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(`{"service": "dynomite"}`))
+	}))
+	defer srv.Close()
+
+	c := NewTargetCollector(Options{
+		Client:      NewHTTPClient(),
+		Timeout:     time.Second,
+		Concurrency: 2,
+	}, log.NewNopLogger())
+	c.SetTargets([]Target{
+		{Name: "dynomite-1", Address: srv.URL + "/1"},
+		{Name: "dynomite-2", Address: srv.URL + "/2"},
+		{Name: "dynomite-3", Address: srv.URL + "/slow", Timeout: 100 * time.Millisecond},
+		{Name: "dynomite-4", Address: srv.URL + "/4"},
+		{Name: "dynomite-5", Address: srv.URL + "/5"},
+	})
+
+	expected := `
+# HELP dynomite_up Could the dynomite server be reached.
+# TYPE dynomite_up gauge
+dynomite_up{target="dynomite-1"} 1
+dynomite_up{target="dynomite-2"} 1
+dynomite_up{target="dynomite-3"} 0
+dynomite_up{target="dynomite-4"} 1
+dynomite_up{target="dynomite-5"} 1
+`
+	start := time.Now()
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "dynomite_up"); err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("collecting took %s, want the slow target bounded by its timeout", elapsed)
+	}
+	if max := atomic.LoadInt32(&maxInFlight); max != 2 {
+		t.Errorf("%d targets scraped at once, want 2", max)
+	}
+}
+
+func TestTargetCollectorCollectContextPartial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-time.After(time.Minute):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Write([]byte(`{"service": "dynomite"}`))
+	}))
+	defer srv.Close()
+
+	c := NewTargetCollector(Options{
+		Client:      NewHTTPClient(),
+		Timeout:     10 * time.Second,
+		Concurrency: 1,
+	}, log.NewNopLogger())
+	c.SetTargets([]Target{
+		{Name: "dynomite-1", Address: srv.URL + "/1"},
+		{Name: "dynomite-2", Address: srv.URL + "/slow"},
+		{Name: "dynomite-3", Address: srv.URL + "/3"},
+	})
+
+	// The scrape ends while the slow target is requested: the target
+	// scraped in time is delivered, the others are reported down.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	expected := `
+# HELP dynomite_up Could the dynomite server be reached.
+# TYPE dynomite_up gauge
+dynomite_up{target="dynomite-1"} 1
+dynomite_up{target="dynomite-2"} 0
+dynomite_up{target="dynomite-3"} 0
+`
+	if err := testutil.CollectAndCompare(WithContext(ctx, c), strings.NewReader(expected), "dynomite_up"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Maxim Pogozhiy
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// ClusterDescription is the ring as seen by a dynomite node, as reported by
+// its /cluster_describe admin endpoint.
+type ClusterDescription struct {
+	Dcs []ClusterDc `json:"dcs"`
+}
+
+// ClusterDc is a datacenter of the ring.
+type ClusterDc struct {
+	Name  string        `json:"name"`
+	Racks []ClusterRack `json:"racks"`
+}
+
+// ClusterRack is a rack of a datacenter of the ring.
+type ClusterRack struct {
+	Name    string        `json:"name"`
+	Servers []ClusterNode `json:"servers"`
+}
+
+// ClusterNode is a node of the ring.
+type ClusterNode struct {
+	Name  string `json:"name"`
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	Token Token  `json:"token"`
+}
+
+// Token is a ring token. Dynomite reports tokens either as numbers or, when
+// a node owns several, as a comma separated string.
+type Token string
+
+// UnmarshalJSON accepts both numeric and string tokens.
+func (t *Token) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*t = Token(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*t = Token(n)
+	return nil
+}
+
+// GetClusterDescription fetches the ring as seen by the node whose stats are
+// served at address from its /cluster_describe admin endpoint.
+func GetClusterDescription(ctx context.Context, client *http.Client, address string) (ClusterDescription, error) {
+	var desc ClusterDescription
+
+	body, err := getAdmin(ctx, client, address, "/cluster_describe")
+	if err != nil {
+		return desc, err
+	}
+	err = json.Unmarshal(body, &desc)
+	return desc, err
+}
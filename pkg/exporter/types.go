@@ -56,51 +56,123 @@ type DynomiteMetrics struct {
 	AllocMbufs                  int    `json:"alloc_mbufs"`
 	FreeMbufs                   int    `json:"free_mbufs"`
 	DynMemory                   int    `json:"dyn_memory"`
-	DynOMite                    struct {
-		ClientEOF                  int   `json:"client_eof"`
-		ClientErr                  int   `json:"client_err"`
-		ClientConnections          int   `json:"client_connections"`
-		ClientReadRequests         int   `json:"client_read_requests"`
-		ClientWriteRequests        int   `json:"client_write_requests"`
-		ClientDroppedRequests      int   `json:"client_dropped_requests"`
-		ClientNonQuorumWResponses  int   `json:"client_non_quorum_w_responses"`
-		ClientNonQuorumRResponses  int   `json:"client_non_quorum_r_responses"`
-		ServerEjects               int   `json:"server_ejects"`
-		DnodeClientEOF             int   `json:"dnode_client_eof"`
-		DnodeClientErr             int   `json:"dnode_client_err"`
-		DnodeClientConnections     int   `json:"dnode_client_connections"`
-		DnodeClientInQueue         int   `json:"dnode_client_in_queue"`
-		DnodeClientInQueueBytes    int   `json:"dnode_client_in_queue_bytes"`
-		DnodeClientOutQueue        int   `json:"dnode_client_out_queue"`
-		DnodeClientOutQueueBytes   int   `json:"dnode_client_out_queue_bytes"`
-		PeerDroppedRequests        int   `json:"peer_dropped_requests"`
-		PeerTimedoutRequests       int   `json:"peer_timedout_requests"`
-		RemotePeerDroppedRequests  int   `json:"remote_peer_dropped_requests"`
-		RemotePeerTimedoutRequests int   `json:"remote_peer_timedout_requests"`
-		RemotePeerFailoverRequests int   `json:"remote_peer_failover_requests"`
-		PeerEOF                    int   `json:"peer_eof"`
-		PeerErr                    int   `json:"peer_err"`
-		PeerTimedout               int   `json:"peer_timedout"`
-		RemotePeerTimedout         int   `json:"remote_peer_timedout"`
-		PeerConnections            int   `json:"peer_connections"`
-		PeerForwardError           int   `json:"peer_forward_error"`
-		PeerRequests               int   `json:"peer_requests"`
-		PeerRequestBytes           int64 `json:"peer_request_bytes"`
-		PeerResponses              int   `json:"peer_responses"`
-		PeerResponseBytes          int   `json:"peer_response_bytes"`
-		PeerEjectedAt              int64 `json:"peer_ejected_at"`
-		PeerEjects                 int   `json:"peer_ejects"`
-		PeerInQueue                int   `json:"peer_in_queue"`
-		RemotePeerInQueue          int   `json:"remote_peer_in_queue"`
-		PeerInQueueBytes           int   `json:"peer_in_queue_bytes"`
-		RemotePeerInQueueBytes     int   `json:"remote_peer_in_queue_bytes"`
-		PeerOutQueue               int   `json:"peer_out_queue"`
-		RemotePeerOutQueue         int   `json:"remote_peer_out_queue"`
-		PeerOutQueueBytes          int   `json:"peer_out_queue_bytes"`
-		RemotePeerOutQueueBytes    int   `json:"remote_peer_out_queue_bytes"`
-		PeerMismatchRequests       int   `json:"peer_mismatch_requests"`
-		ForwardError               int   `json:"forward_error"`
-		Fragments                  int   `json:"fragments"`
-		StatsCount                 int   `json:"stats_count"`
-	} `json:"dyn_o_mite"`
+
+	// Pools holds the statistics of the pools of the node, keyed by the pool
+	// name configured in dynomite.yml, such as dyn_o_mite.
+	Pools map[string]PoolMetrics `json:"-"`
+
+	// SkippedFields lists the statistics that could not be decoded and were
+	// left zero, as dotted keys.
+	SkippedFields []string `json:"-"`
+}
+
+// UnmarshalJSON decodes a stats document leniently, see statsDecoder.
+func (m *DynomiteMetrics) UnmarshalJSON(data []byte) error {
+	stats, err := decodeStats(data)
+	if err != nil {
+		return err
+	}
+	*m = stats
+	return nil
+}
+
+// PoolMetrics holds the statistics dynomite reports for its pool.
+type PoolMetrics struct {
+	ClientEOF                  int   `json:"client_eof"`
+	ClientErr                  int   `json:"client_err"`
+	ClientConnections          int   `json:"client_connections"`
+	ClientReadRequests         int   `json:"client_read_requests"`
+	ClientWriteRequests        int   `json:"client_write_requests"`
+	ClientDroppedRequests      int   `json:"client_dropped_requests"`
+	ClientNonQuorumWResponses  int   `json:"client_non_quorum_w_responses"`
+	ClientNonQuorumRResponses  int   `json:"client_non_quorum_r_responses"`
+	ServerEjects               int   `json:"server_ejects"`
+	DnodeClientEOF             int   `json:"dnode_client_eof"`
+	DnodeClientErr             int   `json:"dnode_client_err"`
+	DnodeClientConnections     int   `json:"dnode_client_connections"`
+	DnodeClientInQueue         int   `json:"dnode_client_in_queue"`
+	DnodeClientInQueueBytes    int   `json:"dnode_client_in_queue_bytes"`
+	DnodeClientOutQueue        int   `json:"dnode_client_out_queue"`
+	DnodeClientOutQueueBytes   int   `json:"dnode_client_out_queue_bytes"`
+	PeerDroppedRequests        int   `json:"peer_dropped_requests"`
+	PeerTimedoutRequests       int   `json:"peer_timedout_requests"`
+	RemotePeerDroppedRequests  int   `json:"remote_peer_dropped_requests"`
+	RemotePeerTimedoutRequests int   `json:"remote_peer_timedout_requests"`
+	RemotePeerFailoverRequests int   `json:"remote_peer_failover_requests"`
+	PeerEOF                    int   `json:"peer_eof"`
+	PeerErr                    int   `json:"peer_err"`
+	PeerTimedout               int   `json:"peer_timedout"`
+	RemotePeerTimedout         int   `json:"remote_peer_timedout"`
+	PeerConnections            int   `json:"peer_connections"`
+	PeerForwardError           int   `json:"peer_forward_error"`
+	PeerRequests               int   `json:"peer_requests"`
+	PeerRequestBytes           int64 `json:"peer_request_bytes"`
+	PeerResponses              int   `json:"peer_responses"`
+	PeerResponseBytes          int   `json:"peer_response_bytes"`
+	PeerEjectedAt              int64 `json:"peer_ejected_at"`
+	PeerEjects                 int   `json:"peer_ejects"`
+	PeerInQueue                int   `json:"peer_in_queue"`
+	RemotePeerInQueue          int   `json:"remote_peer_in_queue"`
+	PeerInQueueBytes           int   `json:"peer_in_queue_bytes"`
+	RemotePeerInQueueBytes     int   `json:"remote_peer_in_queue_bytes"`
+	PeerOutQueue               int   `json:"peer_out_queue"`
+	RemotePeerOutQueue         int   `json:"remote_peer_out_queue"`
+	PeerOutQueueBytes          int   `json:"peer_out_queue_bytes"`
+	RemotePeerOutQueueBytes    int   `json:"remote_peer_out_queue_bytes"`
+	PeerMismatchRequests       int   `json:"peer_mismatch_requests"`
+	ForwardError               int   `json:"forward_error"`
+	Fragments                  int   `json:"fragments"`
+	StatsCount                 int   `json:"stats_count"`
+
+	// Servers holds the statistics of the datastore servers of the pool,
+	// keyed by server name.
+	Servers map[string]ServerMetrics `json:"-"`
+	// Peers holds the statistics of the dnode peers of the pool, keyed by
+	// peer name.
+	Peers map[string]PeerMetrics `json:"-"`
+}
+
+// ServerMetrics holds the statistics dynomite reports for a datastore server.
+type ServerMetrics struct {
+	ServerEOF              int   `json:"server_eof"`
+	ServerErr              int   `json:"server_err"`
+	ServerTimedout         int   `json:"server_timedout"`
+	ServerConnections      int   `json:"server_connections"`
+	ServerEjectedAt        int64 `json:"server_ejected_at"`
+	ServerDroppedRequests  int   `json:"server_dropped_requests"`
+	ServerTimedoutRequests int   `json:"server_timedout_requests"`
+	ReadRequests           int   `json:"read_requests"`
+	ReadRequestBytes       int64 `json:"read_request_bytes"`
+	WriteRequests          int   `json:"write_requests"`
+	WriteRequestBytes      int64 `json:"write_request_bytes"`
+	ReadResponses          int   `json:"read_responses"`
+	ReadResponseBytes      int64 `json:"read_response_bytes"`
+	WriteResponses         int   `json:"write_responses"`
+	WriteResponseBytes     int64 `json:"write_response_bytes"`
+	InQueue                int   `json:"in_queue"`
+	InQueueBytes           int   `json:"in_queue_bytes"`
+	OutQueue               int   `json:"out_queue"`
+	OutQueueBytes          int   `json:"out_queue_bytes"`
+}
+
+// PeerMetrics holds the statistics dynomite reports for a dnode peer.
+type PeerMetrics struct {
+	Dc                   string `json:"dc"`
+	Rack                 string `json:"rack"`
+	PeerEOF              int    `json:"peer_eof"`
+	PeerErr              int    `json:"peer_err"`
+	PeerTimedout         int    `json:"peer_timedout"`
+	PeerConnections      int    `json:"peer_connections"`
+	PeerDroppedRequests  int    `json:"peer_dropped_requests"`
+	PeerTimedoutRequests int    `json:"peer_timedout_requests"`
+	PeerRequests         int    `json:"peer_requests"`
+	PeerRequestBytes     int64  `json:"peer_request_bytes"`
+	PeerResponses        int    `json:"peer_responses"`
+	PeerResponseBytes    int64  `json:"peer_response_bytes"`
+	PeerEjectedAt        int64  `json:"peer_ejected_at"`
+	PeerEjects           int    `json:"peer_ejects"`
+	PeerInQueue          int    `json:"peer_in_queue"`
+	PeerInQueueBytes     int    `json:"peer_in_queue_bytes"`
+	PeerOutQueue         int    `json:"peer_out_queue"`
+	PeerOutQueueBytes    int    `json:"peer_out_queue_bytes"`
 }